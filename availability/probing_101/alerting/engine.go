@@ -0,0 +1,192 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dm03514/sre-tutorials/availability/probing_101/slo"
+)
+
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is sent to the notifiers each time a rule starts or stops firing
+// for a target.
+type Alert struct {
+	Rule          string    `json:"rule"`
+	Severity      string    `json:"severity"`
+	State         string    `json:"state"`
+	Probe         string    `json:"probe"`
+	Target        string    `json:"target"`
+	Objective     float64   `json:"objective"`
+	Threshold     float64   `json:"burn_rate_threshold"`
+	LongWindow    string    `json:"long_window"`
+	LongBurnRate  float64   `json:"long_burn_rate"`
+	ShortWindow   string    `json:"short_window"`
+	ShortBurnRate float64   `json:"short_burn_rate"`
+	StartsAt      time.Time `json:"starts_at"`
+	EvaluatedAt   time.Time `json:"evaluated_at"`
+}
+
+type alertKey struct {
+	rule   string
+	target slo.Target
+}
+
+// maxPending is how many undelivered alerts are queued per notifier, the
+// oldest are dropped past it.
+const maxPending = 1000
+
+// Engine evaluates the rules against probe samples and notifies on state
+// changes.  It keeps the firing alerts between evaluations so each
+// transition is only notified once.  Alerts a notifier fails to deliver
+// are queued and retried, in order, on the next evaluation.
+type Engine struct {
+	conf      *RulesConfig
+	notifiers []Notifier
+	firing    map[alertKey]Alert
+	pending   [][]Alert
+}
+
+func NewEngine(conf *RulesConfig, notifiers ...Notifier) *Engine {
+	return &Engine{
+		conf:      conf,
+		notifiers: notifiers,
+		firing:    map[alertKey]Alert{},
+		pending:   make([][]Alert, len(notifiers)),
+	}
+}
+
+// between returns the samples, which must be ordered by time, in
+// [start, end].
+func between(samples []slo.Sample, start time.Time, end time.Time) []slo.Sample {
+	i := sort.Search(len(samples), func(i int) bool {
+		return !samples[i].Time.Before(start)
+	})
+	j := sort.Search(len(samples), func(j int) bool {
+		return samples[j].Time.After(end)
+	})
+	if i > j {
+		return nil
+	}
+	return samples[i:j]
+}
+
+// burnRates returns the availability burn rate of every target over window,
+// targets without any probes in the window are left out.
+func (e *Engine) burnRates(samples []slo.Sample, at time.Time, window time.Duration) map[slo.Target]float64 {
+	o := slo.Objective{
+		Availability: e.conf.Objective,
+		LatencyUnit:  time.Microsecond,
+	}
+
+	rates := map[slo.Target]float64{}
+	for t, sli := range slo.Compute(between(samples, at.Add(-window), at), at, window, o) {
+		if a, ok := sli.Availability(); ok {
+			rates[t] = slo.BurnRate(a, e.conf.Objective)
+		}
+	}
+	return rates
+}
+
+// Evaluate evaluates every rule as of at and returns the alerts that
+// changed state, after sending them to every notifier.  The error is of
+// the notifiers that failed, their alerts are retried on the next
+// evaluation.
+func (e *Engine) Evaluate(samples []slo.Sample, at time.Time) ([]Alert, error) {
+	windows := map[time.Duration]map[slo.Target]float64{}
+	rates := func(w time.Duration) map[slo.Target]float64 {
+		if _, ok := windows[w]; !ok {
+			windows[w] = e.burnRates(samples, at, w)
+		}
+		return windows[w]
+	}
+
+	changed := []Alert{}
+	for _, r := range e.conf.Rules {
+		long := rates(r.LongWindow.Duration)
+		short := rates(r.ShortWindow.Duration)
+
+		seen := map[slo.Target]bool{}
+		for t, longRate := range long {
+			seen[t] = true
+			shortRate, ok := short[t]
+			key := alertKey{rule: r.Name, target: t}
+			active, wasFiring := e.firing[key]
+
+			if ok && longRate > r.BurnRate && shortRate > r.BurnRate {
+				if wasFiring {
+					continue
+				}
+				a := Alert{
+					Rule:          r.Name,
+					Severity:      r.Severity,
+					State:         StateFiring,
+					Probe:         t.Probe,
+					Target:        t.Dst,
+					Objective:     e.conf.Objective,
+					Threshold:     r.BurnRate,
+					LongWindow:    r.LongWindow.String(),
+					LongBurnRate:  longRate,
+					ShortWindow:   r.ShortWindow.String(),
+					ShortBurnRate: shortRate,
+					StartsAt:      at,
+					EvaluatedAt:   at,
+				}
+				e.firing[key] = a
+				changed = append(changed, a)
+				continue
+			}
+
+			if wasFiring {
+				changed = append(changed, e.resolve(key, active, longRate, shortRate, at))
+			}
+		}
+
+		// targets that stopped reporting entirely resolve as well
+		for key, active := range e.firing {
+			if key.rule == r.Name && !seen[key.target] {
+				changed = append(changed, e.resolve(key, active, 0, 0, at))
+			}
+		}
+	}
+
+	return changed, e.deliver(changed)
+}
+
+// deliver sends alerts to every notifier after the alerts still pending
+// for it.  A notifier's delivery stops at its first failure so it always
+// receives a target's firing and resolved alerts in order.
+func (e *Engine) deliver(alerts []Alert) error {
+	var errs []error
+	for i, n := range e.notifiers {
+		queue := append(e.pending[i], alerts...)
+		for len(queue) > 0 {
+			if err := n.Notify(queue[0]); err != nil {
+				errs = append(errs, fmt.Errorf("notifier %d: %s, %d alerts pending", i, err, len(queue)))
+				break
+			}
+			queue = queue[1:]
+		}
+		if len(queue) > maxPending {
+			log.Printf("notifier %d: dropping %d undelivered alerts", i, len(queue)-maxPending)
+			queue = queue[len(queue)-maxPending:]
+		}
+		e.pending[i] = append([]Alert(nil), queue...)
+	}
+	return errors.Join(errs...)
+}
+
+func (e *Engine) resolve(key alertKey, a Alert, longRate float64, shortRate float64, at time.Time) Alert {
+	delete(e.firing, key)
+	a.State = StateResolved
+	a.LongBurnRate = longRate
+	a.ShortBurnRate = shortRate
+	a.EvaluatedAt = at
+	return a
+}
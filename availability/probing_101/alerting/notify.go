@@ -0,0 +1,80 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers firing and resolved alerts.
+type Notifier interface {
+	Notify(Alert) error
+}
+
+// WriterNotifier writes each alert as a line of json, it backs both the
+// stdout and the file notifiers.
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdoutNotifier() *WriterNotifier {
+	return &WriterNotifier{
+		w: os.Stdout,
+	}
+}
+
+// NewFileNotifier appends alerts to the file at path.
+func NewFileNotifier(path string) (*WriterNotifier, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterNotifier{
+		w: f,
+	}, nil
+}
+
+func (wn *WriterNotifier) Notify(a Alert) error {
+	wn.mu.Lock()
+	defer wn.mu.Unlock()
+	return json.NewEncoder(wn.w).Encode(a)
+}
+
+// WebhookNotifier POSTs each alert as json to url.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (wn *WebhookNotifier) Notify(a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	resp, err := wn.client.Post(wn.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %q responded with status %d", wn.url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration that decodes from a json string like "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Rule fires when the error budget burn rate exceeds BurnRate over both the
+// LongWindow and the ShortWindow.  The long window keeps a brief blip from
+// paging, the short window lets the alert resolve soon after the problem
+// stops instead of waiting for the long window to age out.
+type Rule struct {
+	Name        string   `json:"name"`
+	Severity    string   `json:"severity"`
+	LongWindow  Duration `json:"long_window"`
+	ShortWindow Duration `json:"short_window"`
+	BurnRate    float64  `json:"burn_rate"`
+}
+
+// DefaultRules are the multiwindow, multi-burn-rate alerts recommended by
+// the SRE workbook for a 30 day SLO.  The first rule fires after 2% of the
+// monthly budget is spent in an hour, the second after 5% is spent in 6
+// hours and the last after 10% is spent in 3 days.
+var DefaultRules = []Rule{
+	{
+		Name:        "fast-burn",
+		Severity:    "page",
+		LongWindow:  Duration{time.Hour},
+		ShortWindow: Duration{5 * time.Minute},
+		BurnRate:    14.4,
+	},
+	{
+		Name:        "medium-burn",
+		Severity:    "page",
+		LongWindow:  Duration{6 * time.Hour},
+		ShortWindow: Duration{30 * time.Minute},
+		BurnRate:    6,
+	},
+	{
+		Name:        "slow-burn",
+		Severity:    "ticket",
+		LongWindow:  Duration{3 * 24 * time.Hour},
+		ShortWindow: Duration{6 * time.Hour},
+		BurnRate:    1,
+	},
+}
+
+// RulesConfig is the document passed through -rules.
+type RulesConfig struct {
+	// Objective is the availability SLO, ie 0.999
+	Objective float64 `json:"objective"`
+	Rules     []Rule  `json:"rules"`
+}
+
+func LoadRulesConfig(path string) (*RulesConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := &RulesConfig{}
+	if err := json.NewDecoder(f).Decode(conf); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	return conf, conf.Validate()
+}
+
+func (c *RulesConfig) Validate() error {
+	if c.Objective <= 0 || c.Objective >= 1 {
+		return fmt.Errorf("objective must be between 0 and 1, received: %f", c.Objective)
+	}
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("at least one rule is required")
+	}
+	for _, r := range c.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("rule %+v requires a name", r)
+		}
+		if r.ShortWindow.Duration <= 0 || r.LongWindow.Duration <= r.ShortWindow.Duration {
+			return fmt.Errorf("rule %q: short_window must be positive and less than long_window", r.Name)
+		}
+		if r.BurnRate <= 0 {
+			return fmt.Errorf("rule %q: burn_rate must be positive", r.Name)
+		}
+	}
+	return nil
+}
+
+// LongestWindow is how much history is needed to evaluate every rule.
+func (c *RulesConfig) LongestWindow() time.Duration {
+	var longest time.Duration
+	for _, r := range c.Rules {
+		if r.LongWindow.Duration > longest {
+			longest = r.LongWindow.Duration
+		}
+	}
+	return longest
+}
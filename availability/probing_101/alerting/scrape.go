@@ -0,0 +1,109 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dm03514/sre-tutorials/availability/probing_101/slo"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Scraper is a slo.Source that scrapes the cloudprober prometheus
+// endpoint, :9313/metrics by default, and keeps retention worth of the
+// total and success counters in memory.
+type Scraper struct {
+	url       string
+	prefix    string
+	retention time.Duration
+	client    *http.Client
+
+	mu      sync.Mutex
+	samples []slo.Sample
+}
+
+// NewScraper returns a scraper for url.  prefix is cloudprober's
+// metrics_prefix, if one is configured.
+func NewScraper(url string, prefix string, retention time.Duration) *Scraper {
+	return &Scraper{
+		url:       url,
+		prefix:    prefix,
+		retention: retention,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+func metricValue(m *dto.Metric) float64 {
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+	if m.GetGauge() != nil {
+		return m.GetGauge().GetValue()
+	}
+	return m.GetUntyped().GetValue()
+}
+
+// Scrape fetches the current counters and appends them to the retained
+// samples.
+func (s *Scraper) Scrape(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("scraping %q received status %d", s.url, resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	scraped := []slo.Sample{}
+	for _, name := range []string{"total", "success"} {
+		family, ok := families[s.prefix+name]
+		if !ok {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			scraped = append(scraped, slo.Sample{
+				Time:   now,
+				Metric: name,
+				Value:  metricValue(m),
+				Labels: labels,
+			})
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(between(s.samples, now.Add(-s.retention), now), scraped...)
+	return nil
+}
+
+func (s *Scraper) Samples(ctx context.Context, since time.Time) ([]slo.Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := between(s.samples, since, time.Now())
+	samples := make([]slo.Sample, len(matched))
+	copy(samples, matched)
+	return samples, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/dm03514/sre-tutorials/availability/probing_101/slogen"
+)
+
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	specPath := flag.String("spec", "", "path to a json SLO spec")
+	rulesOut := flag.String("rules-out", "", "path the prometheus rule file is written to")
+	dashboardOut := flag.String("dashboard-out", "", "path the grafana dashboard is written to, "+
+		"skipped when empty")
+	datasource := flag.String("datasource", "Prom", "grafana datasource the dashboard queries")
+	flag.Parse()
+
+	if *specPath == "" || *rulesOut == "" {
+		log.Fatal("-spec and -rules-out are required")
+	}
+
+	spec, err := slogen.LoadSpec(*specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	groups, err := spec.RuleGroups()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writeFile(*rulesOut, func(w io.Writer) error {
+		return slogen.WriteRules(w, groups)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %d rule groups to %q", len(groups), *rulesOut)
+
+	if *dashboardOut == "" {
+		return
+	}
+	err = writeFile(*dashboardOut, func(w io.Writer) error {
+		return spec.WriteDashboard(w, *datasource)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote dashboard to %q", *dashboardOut)
+}
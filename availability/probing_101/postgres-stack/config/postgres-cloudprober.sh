@@ -1,13 +1,5 @@
 #!/usr/bin/env sh
 
+# the metrics table is created by the cloudprober migrations of
+# performance/analysis-methodology-simple-http, see make start-postgres-stack
 psql -c "create database cloudprober"
-
-psql -c "create schema cloudprober"
-
-psql cloudprober -c "CREATE TABLE metrics (
-  time TIMESTAMP WITH TIME ZONE,
-  metric_name text NOT NULL,
-  value DOUBLE PRECISION ,
-  labels jsonb,
-  PRIMARY KEY (time, metric_name, labels)
-)"
\ No newline at end of file
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const redirectHopParam = "redirectHop"
+
+// Body configures what the final handler responds with.  At most one of
+// Text, JSONTemplate and RandomSize may be set, when none are the response
+// is the original `OK`.
+type Body struct {
+	Text         string            `json:"text"`
+	JSONTemplate string            `json:"json_template"`
+	RandomSize   int               `json:"random_size"`
+	CorruptRate  int               `json:"corrupt_rate"`
+	TruncateRate int               `json:"truncate_rate"`
+	Headers      map[string]string `json:"headers"`
+	Redirects    int               `json:"redirects"`
+}
+
+// templateData is available to JSONTemplate, ie:
+//
+//	{"path": "{{.Path}}", "count": {{.Count}}, "time": "{{.Time}}"}
+type templateData struct {
+	Path     string
+	Host     string
+	Hostname string
+	Time     string
+	Count    int
+}
+
+// headerFlags implements flag.Value for repeated -header "Key: Value" flags.
+type headerFlags map[string]string
+
+func (hf headerFlags) String() string {
+	return fmt.Sprintf("%v", map[string]string(hf))
+}
+
+func (hf headerFlags) Set(v string) error {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("expected header of format 'Key: Value', received: %q", v)
+	}
+	hf[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
+// newBodyFlags registers the flags that customize the default handler's
+// response.
+func newBodyFlags() *Body {
+	b := &Body{
+		Headers: map[string]string{},
+	}
+	flag.StringVar(&b.Text, "body-text", "", "fixed response body")
+	flag.StringVar(&b.JSONTemplate, "body-json-template", "", "go text/template rendering a json "+
+		"response body, with access to .Path, .Host, .Hostname, .Time and .Count")
+	flag.IntVar(&b.RandomSize, "body-random-size", 0, "respond with this many random bytes")
+	flag.IntVar(&b.CorruptRate, "body-corrupt-rate", 0, "set to determine how many response bodies "+
+		"have a byte corrupted, a rate of 1 will mean every response, etc.")
+	flag.IntVar(&b.TruncateRate, "body-truncate-rate", 0, "set to determine how many response bodies "+
+		"are cut off half way through")
+	flag.Var(headerFlags(b.Headers), "header", "'Key: Value' header added to every response, may be repeated")
+	flag.IntVar(&b.Redirects, "redirects", 0, "number of 302 redirects issued before the response is served")
+	return b
+}
+
+func (b Body) Validate() error {
+	set := 0
+	for _, s := range []bool{b.Text != "", b.JSONTemplate != "", b.RandomSize > 0} {
+		if s {
+			set += 1
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of text, json_template and random_size may be set")
+	}
+	if b.RandomSize < 0 || b.CorruptRate < 0 || b.TruncateRate < 0 || b.Redirects < 0 {
+		return fmt.Errorf("body sizes, rates and redirects must not be negative")
+	}
+
+	if b.JSONTemplate != "" {
+		t, err := template.New("body").Parse(b.JSONTemplate)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, templateData{}); err != nil {
+			return err
+		}
+		if !json.Valid(buf.Bytes()) {
+			return fmt.Errorf("json_template does not render valid json: %q", buf.String())
+		}
+	}
+	return nil
+}
+
+// Handler builds the final handler of a chain from the body config.
+func (b Body) Handler() (handler, error) {
+	if err := b.Validate(); err != nil {
+		return handler{}, err
+	}
+
+	hostname, _ := os.Hostname()
+	rb := &responseBody{
+		text:      []byte(b.Text),
+		size:      b.RandomSize,
+		headers:   b.Headers,
+		redirects: b.Redirects,
+		hostname:  hostname,
+		count:     newFailureCounter(0),
+		corrupt:   newFailureCounter(b.CorruptRate),
+		truncate:  newFailureCounter(b.TruncateRate),
+	}
+	if b.JSONTemplate != "" {
+		rb.tmpl = template.Must(template.New("body").Parse(b.JSONTemplate))
+	}
+	if b.Text == "" && b.JSONTemplate == "" && b.RandomSize == 0 {
+		rb.text = []byte(`OK`)
+	}
+
+	return handler{
+		body: rb,
+	}, nil
+}
+
+type responseBody struct {
+	text      []byte
+	tmpl      *template.Template
+	size      int
+	headers   map[string]string
+	redirects int
+	hostname  string
+
+	count    *failureCounter
+	corrupt  *failureCounter
+	truncate *failureCounter
+}
+
+// redirect sends the client to the next hop when the request hasn't been
+// through the whole redirect chain yet.
+func (rb *responseBody) redirect(w http.ResponseWriter, r *http.Request) bool {
+	hop, _ := strconv.Atoi(r.URL.Query().Get(redirectHopParam))
+	if hop >= rb.redirects {
+		return false
+	}
+
+	q := r.URL.Query()
+	q.Set(redirectHopParam, strconv.Itoa(hop+1))
+	next := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: q.Encode(),
+	}
+	logger.Printf("redirecting hop %d/%d to %q", hop+1, rb.redirects, next.String())
+	http.Redirect(w, r, next.String(), http.StatusFound)
+	return true
+}
+
+func (rb *responseBody) render(r *http.Request) ([]byte, string, error) {
+	numResponses, _ := rb.count.Next()
+
+	switch {
+	case rb.tmpl != nil:
+		var buf bytes.Buffer
+		err := rb.tmpl.Execute(&buf, templateData{
+			Path:     r.URL.Path,
+			Host:     r.Host,
+			Hostname: rb.hostname,
+			Time:     time.Now().UTC().Format(time.RFC3339Nano),
+			Count:    numResponses,
+		})
+		return buf.Bytes(), "application/json", err
+	case rb.size > 0:
+		const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		body := make([]byte, rb.size)
+		for i := range body {
+			body[i] = letters[rand.Intn(len(letters))]
+		}
+		return body, "application/octet-stream", nil
+	default:
+		return rb.text, "text/plain; charset=utf-8", nil
+	}
+}
+
+func (rb *responseBody) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for k, v := range rb.headers {
+		w.Header().Set(k, v)
+	}
+
+	if rb.redirect(w, r) {
+		return
+	}
+
+	body, contentType, err := rb.render(r)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	if numResponses, corrupt := rb.corrupt.Next(); corrupt && len(body) > 0 {
+		logger.Printf("corrupting response body, responses count: %d w/ rate of %d",
+			numResponses, rb.corrupt.rate)
+		corrupted := make([]byte, len(body))
+		copy(corrupted, body)
+		i := rand.Intn(len(corrupted))
+		corrupted[i] = corrupted[i] ^ 0xff
+		body = corrupted
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if numResponses, truncate := rb.truncate.Next(); truncate {
+		logger.Printf("truncating response body, responses count: %d w/ rate of %d",
+			numResponses, rb.truncate.rate)
+		w.Write(body[:len(body)/2])
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		// aborts the connection leaving the client short of Content-Length
+		panic(http.ErrAbortHandler)
+	}
+
+	w.Write(body)
+}
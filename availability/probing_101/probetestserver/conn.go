@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type connFlags struct {
+	resetRate          *int
+	maxConns           *int
+	backlog            *int
+	slowBodyRate       *int
+	slowBodyDelay      *time.Duration
+	slowHandshakeRate  *int
+	slowHandshakeDelay *time.Duration
+}
+
+func newConnFlags() connFlags {
+	return connFlags{
+		resetRate: flag.Int("conn-reset-rate", 0, "set to determine how many accepted http connections "+
+			"are reset before a request is read, a rate of 1 will mean every connection, etc."),
+		maxConns: flag.Int("max-conns", 0, "maximum concurrently served http connections, 0 is unlimited"),
+		backlog: flag.Int("conn-backlog", 0, "connections over -max-conns that wait for a free slot, "+
+			"connections over the backlog are reset"),
+		slowBodyRate: flag.Int("slow-body-rate", 0, "set to determine how many responses trickle their "+
+			"body out one byte at a time"),
+		slowBodyDelay: flag.Duration("slow-body-delay", 100*time.Millisecond, "delay between each byte "+
+			"of a slow response body"),
+		slowHandshakeRate: flag.Int("slow-tls-handshake-rate", 0, "set to determine how many tls "+
+			"handshakes are delayed"),
+		slowHandshakeDelay: flag.Duration("slow-tls-handshake-delay", time.Second, "delay added to "+
+			"slow tls handshakes"),
+	}
+}
+
+// Listener wraps l with the connection level faults.
+func (cf connFlags) Listener(l net.Listener) net.Listener {
+	if *cf.maxConns > 0 {
+		l = newLimitListener(l, *cf.maxConns, *cf.backlog)
+	}
+	if *cf.resetRate > 0 {
+		l = resetListener{
+			Listener: l,
+			failures: newFailureCounter(*cf.resetRate),
+		}
+	}
+	return l
+}
+
+// Handler wraps next with the slow body fault.
+func (cf connFlags) Handler(next http.Handler) http.Handler {
+	if *cf.slowBodyRate == 0 {
+		return next
+	}
+	return slowBodyHandler{
+		next:     next,
+		failures: newFailureCounter(*cf.slowBodyRate),
+		delay:    *cf.slowBodyDelay,
+	}
+}
+
+// TLSConfig adds the slow handshake fault to conf.  The delay happens
+// after the ClientHello is received so the client has already committed to
+// the handshake and is waiting on the server.
+func (cf connFlags) TLSConfig(conf *tls.Config) {
+	if *cf.slowHandshakeRate == 0 {
+		return
+	}
+	failures := newFailureCounter(*cf.slowHandshakeRate)
+	conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if numHandshakes, slow := failures.Next(); slow {
+			logger.Printf("delaying tls handshake from %s by %s, handshakes count: %d w/ rate of %d",
+				hello.Conn.RemoteAddr(), *cf.slowHandshakeDelay, numHandshakes, failures.rate)
+			time.Sleep(*cf.slowHandshakeDelay)
+		}
+		return nil, nil
+	}
+}
+
+// resetConn closes conn with SO_LINGER 0 so the peer receives a RST
+// instead of a FIN.  Wrapped conns, ie a limitConn, are unwrapped to set
+// the linger but closed through the wrapper so it can clean up.
+func resetConn(conn net.Conn) {
+	inner := conn
+	for {
+		w, ok := inner.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		inner = w.NetConn()
+	}
+	if tc, ok := inner.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// resetListener resets every Nth accepted connection before it is handed to
+// the server.
+type resetListener struct {
+	net.Listener
+	failures *failureCounter
+}
+
+func (rl resetListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := rl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		numConns, fail := rl.failures.Next()
+		if !fail {
+			return conn, nil
+		}
+		logger.Printf("resetting connection from %s, connections count: %d w/ rate of %d",
+			conn.RemoteAddr(), numConns, rl.failures.rate)
+		resetConn(conn)
+	}
+}
+
+// limitListener serves at most maxConns connections at a time.  Up to
+// backlog more connections are accepted and held until a slot frees up,
+// anything past that is reset, similar to a server with a full accept
+// queue.
+type limitListener struct {
+	net.Listener
+
+	slots   chan struct{}
+	backlog chan struct{}
+	ready   chan net.Conn
+	errs    chan error
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newLimitListener(l net.Listener, maxConns int, backlog int) *limitListener {
+	ll := &limitListener{
+		Listener: l,
+		slots:    make(chan struct{}, maxConns),
+		backlog:  make(chan struct{}, backlog),
+		ready:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go ll.acceptLoop()
+	return ll
+}
+
+func (ll *limitListener) acceptLoop() {
+	for {
+		conn, err := ll.Listener.Accept()
+		if err != nil {
+			ll.errs <- err
+			return
+		}
+
+		select {
+		case ll.slots <- struct{}{}:
+			ll.deliver(conn)
+			continue
+		default:
+		}
+
+		select {
+		case ll.backlog <- struct{}{}:
+			logger.Printf("max connections reached, queueing connection from %s", conn.RemoteAddr())
+			go func() {
+				defer func() { <-ll.backlog }()
+				select {
+				case ll.slots <- struct{}{}:
+					ll.deliver(conn)
+				case <-ll.done:
+					conn.Close()
+				}
+			}()
+		default:
+			logger.Printf("max connections and backlog reached, resetting connection from %s",
+				conn.RemoteAddr())
+			resetConn(conn)
+		}
+	}
+}
+
+// deliver hands conn, which already holds a slot, to Accept.
+func (ll *limitListener) deliver(conn net.Conn) {
+	select {
+	case ll.ready <- &limitConn{Conn: conn, release: func() { <-ll.slots }}:
+	case <-ll.done:
+		conn.Close()
+		<-ll.slots
+	}
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ll.ready:
+		return conn, nil
+	case err := <-ll.errs:
+		return nil, err
+	}
+}
+
+func (ll *limitListener) Close() error {
+	ll.once.Do(func() { close(ll.done) })
+	return ll.Listener.Close()
+}
+
+// limitConn frees its slot in the limitListener when closed.
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+// NetConn returns the wrapped conn, like tls.Conn.NetConn.
+func (lc *limitConn) NetConn() net.Conn {
+	return lc.Conn
+}
+
+func (lc *limitConn) Close() error {
+	lc.once.Do(lc.release)
+	return lc.Conn.Close()
+}
+
+// slowBodyHandler makes every Nth response trickle out one byte at a time,
+// slow-loris style.  The status and headers go out with the first byte so
+// probes see a healthy response until the body stalls.
+type slowBodyHandler struct {
+	next     http.Handler
+	failures *failureCounter
+	delay    time.Duration
+}
+
+func (sh slowBodyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if numRequests, slow := sh.failures.Next(); slow {
+		logger.Printf("slowing response body, requests count: %d w/ rate of %d",
+			numRequests, sh.failures.rate)
+		w = &slowResponseWriter{
+			ResponseWriter: w,
+			delay:          sh.delay,
+		}
+	}
+	sh.next.ServeHTTP(w, r)
+}
+
+type slowResponseWriter struct {
+	http.ResponseWriter
+	delay time.Duration
+}
+
+func (sw *slowResponseWriter) Write(b []byte) (int, error) {
+	flusher, _ := sw.ResponseWriter.(http.Flusher)
+	for i := range b {
+		if _, err := sw.ResponseWriter.Write(b[i : i+1]); err != nil {
+			return i, err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		time.Sleep(sw.delay)
+	}
+	return len(b), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+const (
+	dnsTypeA   = 1
+	dnsClassIN = 1
+
+	dnsRcodeNoError  = 0
+	dnsRcodeFormErr  = 1
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+	dnsRcodeNotImp   = 4
+
+	dnsHeaderLen = 12
+)
+
+// dnsServer is a minimal authoritative responder that answers every A query
+// with a single fixed address.  Other query types get an empty NOERROR
+// response.  The profile's failures are answered with SERVFAIL and the
+// nxdomain counter answers with NXDOMAIN, letting a DNS probe see both
+// server side and "record is missing" failures.
+type dnsServer struct {
+	addr     string
+	answer   net.IP
+	profile  faultProfile
+	nxdomain *failureCounter
+
+	drain drain
+	mu    sync.Mutex
+	conn  net.PacketConn
+}
+
+func (s *dnsServer) ListenAndServe() error {
+	if s.answer.To4() == nil {
+		return fmt.Errorf("dns answer %q must be an ipv4 address", s.answer)
+	}
+
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	logger.Printf("starting dns server on: %q\n", s.addr)
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.drain.isClosing() {
+				return nil
+			}
+			return err
+		}
+		if !s.drain.start() {
+			continue
+		}
+
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			defer s.drain.done()
+			s.profile.Delay()
+			resp, err := s.respond(query)
+			if err != nil {
+				logger.Printf("dns: dropping query from %s: %s", addr, err)
+				return
+			}
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				logger.Printf("dns: error responding to %s: %s", addr, err)
+			}
+		}()
+	}
+}
+
+// Shutdown drops new queries and waits for the delayed responses to be
+// sent before closing the socket.
+func (s *dnsServer) Shutdown(ctx context.Context) error {
+	s.drain.close()
+	err := s.drain.wait(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return err
+}
+
+// respond builds the response to a single query.  An error is only returned
+// for packets too malformed to answer at all.
+func (s *dnsServer) respond(query []byte) ([]byte, error) {
+	if len(query) < dnsHeaderLen {
+		return nil, fmt.Errorf("query of %d bytes is shorter than a dns header", len(query))
+	}
+
+	flags := binary.BigEndian.Uint16(query[2:4])
+	if flags&0x8000 != 0 {
+		return nil, fmt.Errorf("received a response, not a query")
+	}
+
+	qdcount := binary.BigEndian.Uint16(query[4:6])
+	questionEnd, qtype, err := parseQuestion(query)
+	if err != nil || qdcount != 1 {
+		return dnsHeader(query, dnsRcodeFormErr, 0), nil
+	}
+
+	question := query[dnsHeaderLen:questionEnd]
+
+	if opcode := (flags >> 11) & 0xf; opcode != 0 {
+		return append(dnsHeader(query, dnsRcodeNotImp, 0), question...), nil
+	}
+
+	if numQueries, fail := s.profile.failures.Next(); fail {
+		logger.Printf("dns: SERVFAIL, queries count: %d w/ rate of %d", numQueries, s.profile.failures.rate)
+		return append(dnsHeader(query, dnsRcodeServFail, 0), question...), nil
+	}
+
+	if numQueries, fail := s.nxdomain.Next(); fail {
+		logger.Printf("dns: NXDOMAIN, queries count: %d w/ rate of %d", numQueries, s.nxdomain.rate)
+		return append(dnsHeader(query, dnsRcodeNXDomain, 0), question...), nil
+	}
+
+	if qtype != dnsTypeA {
+		return append(dnsHeader(query, dnsRcodeNoError, 0), question...), nil
+	}
+
+	resp := append(dnsHeader(query, dnsRcodeNoError, 1), question...)
+	answer := make([]byte, 16)
+	// compressed name pointing at the question name
+	binary.BigEndian.PutUint16(answer[0:2], 0xc000|dnsHeaderLen)
+	binary.BigEndian.PutUint16(answer[2:4], dnsTypeA)
+	binary.BigEndian.PutUint16(answer[4:6], dnsClassIN)
+	binary.BigEndian.PutUint32(answer[6:10], 60)
+	binary.BigEndian.PutUint16(answer[10:12], net.IPv4len)
+	copy(answer[12:], s.answer.To4())
+
+	return append(resp, answer...), nil
+}
+
+// parseQuestion returns the offset the first question ends at along with
+// its type.
+func parseQuestion(query []byte) (int, uint16, error) {
+	i := dnsHeaderLen
+	for {
+		if i >= len(query) {
+			return 0, 0, fmt.Errorf("question name overruns packet")
+		}
+		l := int(query[i])
+		if l == 0 {
+			i++
+			break
+		}
+		if l&0xc0 != 0 {
+			return 0, 0, fmt.Errorf("compressed question names are not supported")
+		}
+		i += l + 1
+	}
+
+	if i+4 > len(query) {
+		return 0, 0, fmt.Errorf("question type/class overruns packet")
+	}
+	return i + 4, binary.BigEndian.Uint16(query[i : i+2]), nil
+}
+
+// dnsHeader builds a response header for query with a single question.
+func dnsHeader(query []byte, rcode uint16, ancount uint16) []byte {
+	h := make([]byte, dnsHeaderLen)
+	copy(h[0:2], query[0:2])
+
+	qflags := binary.BigEndian.Uint16(query[2:4])
+	// QR, AA, RA + the query's opcode and RD
+	flags := uint16(0x8000|0x0400|0x0080) | (qflags & 0x7900) | rcode
+	binary.BigEndian.PutUint16(h[2:4], flags)
+
+	if rcode != dnsRcodeFormErr {
+		binary.BigEndian.PutUint16(h[4:6], 1)
+	}
+	binary.BigEndian.PutUint16(h[6:8], ancount)
+	return h
+}
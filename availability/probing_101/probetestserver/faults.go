@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// failureCounter implements the failure model shared by every listener:
+// with a rate of N, every Nth event (request, connection, packet) fails.
+// A rate of 0 disables failures.
+type failureCounter struct {
+	rate int
+
+	mu    sync.Mutex
+	count int
+}
+
+func newFailureCounter(rate int) *failureCounter {
+	return &failureCounter{
+		rate: rate,
+	}
+}
+
+// Next records an event and returns its sequence number and whether it
+// should fail.
+func (fc *failureCounter) Next() (int, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.count += 1
+	return fc.count, fc.rate > 0 && fc.count%fc.rate == 0
+}
+
+// NextFails reports whether the next event will fail without recording
+// it, for failures that have to be set up before the event happens.
+func (fc *failureCounter) NextFails() bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.rate > 0 && (fc.count+1)%fc.rate == 0
+}
+
+func randDuration(max time.Duration, min time.Duration) time.Duration {
+	if max.Nanoseconds() == min.Nanoseconds() {
+		return min
+	}
+
+	r := rand.Intn(
+		int(max.Nanoseconds())-int(min.Nanoseconds())) + int(min.Nanoseconds())
+
+	return time.Duration(r) * time.Nanosecond
+}
+
+// faultProfile is the latency and failure configuration of a single
+// non-http listener.
+type faultProfile struct {
+	failures    *failureCounter
+	minDuration time.Duration
+	maxDuration time.Duration
+}
+
+// Delay sleeps for a random duration between the profile's min and max.
+func (fp faultProfile) Delay() {
+	time.Sleep(randDuration(fp.maxDuration, fp.minDuration))
+}
+
+type listenerFlags struct {
+	addr        *string
+	failureRate *int
+	minDuration *time.Duration
+	maxDuration *time.Duration
+}
+
+// newListenerFlags registers the addr and fault injection flags for the
+// listener named prefix, ie -tcp-addr, -tcp-failure-rate, ...
+func newListenerFlags(prefix string, failureUsage string) listenerFlags {
+	return listenerFlags{
+		addr: flag.String(prefix+"-addr", "", "addr/port for the "+prefix+
+			" listener, the listener is disabled when empty"),
+		failureRate: flag.Int(prefix+"-failure-rate", 0, failureUsage+
+			", a rate of 1 will mean every one, a rate of two will mean every other one, etc."),
+		minDuration: flag.Duration(prefix+"-min-duration", 0, "minimum injected latency for the "+prefix+" listener"),
+		maxDuration: flag.Duration(prefix+"-max-duration", 0, "maximum injected latency for the "+prefix+" listener"),
+	}
+}
+
+func (lf listenerFlags) Enabled() bool {
+	return *lf.addr != ""
+}
+
+func (lf listenerFlags) Profile() faultProfile {
+	max := *lf.maxDuration
+	if max < *lf.minDuration {
+		max = *lf.minDuration
+	}
+	return faultProfile{
+		failures:    newFailureCounter(*lf.failureRate),
+		minDuration: *lf.minDuration,
+		maxDuration: max,
+	}
+}
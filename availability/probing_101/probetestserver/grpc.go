@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const (
+	grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+	// grpc.health.v1.HealthCheckResponse.ServingStatus
+	grpcServing    = 1
+	grpcNotServing = 2
+
+	grpcCodeOK            = 0
+	grpcCodeExhausted     = 8
+	grpcCodeInternal      = 13
+	grpcCodeUnimplemented = 12
+
+	// maxMessageSize is grpc's default limit of received messages, the
+	// length prefix is read before anything else of the message so it
+	// bounds what a client can make the server allocate.
+	maxMessageSize = 4 << 20
+)
+
+var errMessageTooLarge = errors.New("grpc message is larger than the 4MiB limit")
+
+// grpcHealthServer implements grpc.health.v1.Health/Check over cleartext
+// HTTP/2 using only the standard library.  The messages involved are small
+// enough to encode by hand which keeps the test server free of the grpc and
+// protobuf dependencies.  Failed checks respond with NOT_SERVING.
+type grpcHealthServer struct {
+	addr    string
+	profile faultProfile
+
+	once   sync.Once
+	server *http.Server
+}
+
+func (s *grpcHealthServer) httpServer() *http.Server {
+	s.once.Do(func() {
+		var protocols http.Protocols
+		protocols.SetUnencryptedHTTP2(true)
+
+		s.server = &http.Server{
+			Addr:      s.addr,
+			Handler:   s,
+			Protocols: &protocols,
+		}
+	})
+	return s.server
+}
+
+func (s *grpcHealthServer) ListenAndServe() error {
+	logger.Printf("starting grpc health server on: %q\n", s.addr)
+	if err := s.httpServer().ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *grpcHealthServer) Shutdown(ctx context.Context) error {
+	return s.httpServer().Shutdown(ctx)
+}
+
+func (s *grpcHealthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("grpcHealthServer.ServeHTTP()")
+	defer r.Body.Close()
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	if r.Method != http.MethodPost || r.URL.Path != grpcHealthCheckPath {
+		writeGRPCStatus(w, grpcCodeUnimplemented, fmt.Sprintf("unknown method %q", r.URL.Path))
+		return
+	}
+
+	service, err := readHealthCheckRequest(r.Body)
+	if err == errMessageTooLarge {
+		writeGRPCStatus(w, grpcCodeExhausted, err.Error())
+		return
+	}
+	if err != nil {
+		writeGRPCStatus(w, grpcCodeInternal, err.Error())
+		return
+	}
+	logger.Printf("grpc: health check for service %q", service)
+
+	s.profile.Delay()
+
+	status := grpcServing
+	if numChecks, fail := s.profile.failures.Next(); fail {
+		logger.Printf("grpc: NOT_SERVING, checks count: %d w/ rate of %d", numChecks, s.profile.failures.rate)
+		status = grpcNotServing
+	}
+
+	// HealthCheckResponse{status: <status>}, field 1 varint
+	w.Write(grpcFrame([]byte{0x08, byte(status)}))
+	writeGRPCStatus(w, grpcCodeOK, "")
+}
+
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+}
+
+// grpcFrame prefixes msg with the uncompressed grpc length prefixed message
+// header.
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// readHealthCheckRequest reads a single HealthCheckRequest message and
+// returns its service field.
+func readHealthCheckRequest(body io.Reader) (string, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return "", fmt.Errorf("reading grpc message header: %s", err)
+	}
+	if header[0] != 0 {
+		return "", fmt.Errorf("compressed grpc messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxMessageSize {
+		return "", errMessageTooLarge
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return "", fmt.Errorf("reading grpc message: %s", err)
+	}
+
+	var service string
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return "", fmt.Errorf("malformed HealthCheckRequest")
+		}
+		msg = msg[n:]
+
+		switch key & 0x7 {
+		case 0: // varint
+			_, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return "", fmt.Errorf("malformed HealthCheckRequest")
+			}
+			msg = msg[n:]
+		case 2: // length delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return "", fmt.Errorf("malformed HealthCheckRequest")
+			}
+			if key>>3 == 1 {
+				service = string(msg[n : n+int(l)])
+			}
+			msg = msg[n+int(l):]
+		default:
+			return "", fmt.Errorf("unexpected wire type %d in HealthCheckRequest", key&0x7)
+		}
+	}
+	return service, nil
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -28,29 +31,27 @@ func durationFromString(ds string) (time.Duration, error) {
 }
 
 type artificialFailureHandler struct {
-	next               http.Handler
-	requestFailureRate int
-
-	mu          sync.Mutex
-	numRequests int
+	next     http.Handler
+	failures *failureCounter
 }
 
 func (h *artificialFailureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("artificialFailureHandler.ServeHTTP()")
-	h.mu.Lock()
-	h.numRequests += 1
-	h.mu.Unlock()
 
-	if h.requestFailureRate > 0 && h.numRequests%h.requestFailureRate == 0 {
+	if numRequests, fail := h.failures.Next(); fail {
 		panic(fmt.Errorf(
-			"injected error, requests count: %d w/ rate of %d", h.numRequests, h.requestFailureRate,
+			"injected error, requests count: %d w/ rate of %d", numRequests, h.failures.rate,
 		))
 	}
 
 	h.next.ServeHTTP(w, r)
 }
 
-type handler struct{}
+// handler is the end of every chain.  It drains the request and responds
+// with body, or a plain `OK` when body isn't set.
+type handler struct {
+	body http.Handler
+}
 
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("handler.ServeHTTP()")
@@ -61,70 +62,251 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if h.body != nil {
+		h.body.ServeHTTP(w, r)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`OK`))
 }
 
+// latencyHandler sleeps for a random duration between minDuration and
+// maxDuration.  The minDuration/maxDuration query params override the
+// configured values.
 type latencyHandler struct {
-	next http.Handler
-}
-
-func (lh latencyHandler) RandDuration(max time.Duration, min time.Duration) time.Duration {
-	if max.Nanoseconds() == 0 && min.Nanoseconds() == 0 {
-		return 0
-	}
-
-	r := rand.Intn(
-		int(max.Nanoseconds())-int(min.Nanoseconds())) + int(min.Nanoseconds())
-
-	return time.Duration(r) * time.Nanosecond
+	minDuration time.Duration
+	maxDuration time.Duration
+	next        http.Handler
 }
 
 func (lh latencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger.Printf("latencyHandler.ServeHTTP()")
 
-	minDuration, err := durationFromString(r.URL.Query().Get("minDuration"))
-	if err != nil {
-		panic(err)
+	minDuration, maxDuration := lh.minDuration, lh.maxDuration
+	var err error
+
+	if v := r.URL.Query().Get("minDuration"); v != "" {
+		if minDuration, err = durationFromString(v); err != nil {
+			panic(err)
+		}
 	}
 
-	maxDuration, err := durationFromString(r.URL.Query().Get("maxDuration"))
-	if err != nil {
-		panic(err)
+	if v := r.URL.Query().Get("maxDuration"); v != "" {
+		if maxDuration, err = durationFromString(v); err != nil {
+			panic(err)
+		}
 	}
 	if maxDuration.Nanoseconds() < minDuration.Nanoseconds() {
 		panic(fmt.Errorf("maxDuration (%s) less than minDuration (%s)",
 			maxDuration, minDuration))
 	}
 
-	d := lh.RandDuration(maxDuration, minDuration)
+	d := randDuration(maxDuration, minDuration)
 	logger.Printf("sleeping for %s", d)
 	time.Sleep(d)
 
 	lh.next.ServeHTTP(w, r)
 }
 
+// listener is implemented by each of the optional non-http servers.
+// ListenAndServe returns nil once Shutdown is called.  Shutdown stops
+// accepting new connections or packets and waits for the in flight ones
+// until ctx is done, the way http.Server.Shutdown does.
+type listener interface {
+	ListenAndServe() error
+	Shutdown(ctx context.Context) error
+}
+
+// drain tracks the in flight connections or packets of a listener so
+// Shutdown can wait for them.
+type drain struct {
+	mu      sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// start records new work, it returns false once the listener is shutting
+// down and the work should be dropped.
+func (d *drain) start() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closing {
+		return false
+	}
+	d.wg.Add(1)
+	return true
+}
+
+func (d *drain) done() {
+	d.wg.Done()
+}
+
+// close stops new work from starting.
+func (d *drain) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closing = true
+}
+
+func (d *drain) isClosing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+// wait waits for the work in flight until ctx is done.
+func (d *drain) wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func main() {
 	var requestFailureRate = flag.Int("request-failure-rate", 0, "set to determine how many requests "+
 		"should fail.  The default are 0 artificially failed requests, a rate of 1 will mean every request, a rate of two will mean "+
 		"every other request, etc.")
 	var addr = flag.String("addr", "127.0.0.1:5000", "addr/port for the tes")
+	tlsFlags := newTLSFlags()
+	connFlags := newConnFlags()
+	bodyFlags := newBodyFlags()
+	var shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long in flight "+
+		"requests have to finish after SIGTERM/SIGINT before the server exits")
+	var routesConfig = flag.String("routes-config", "", "path to a json routes config.  When set each "+
+		"route is served with its own latency and failure profile instead of the single default handler")
+	tcpFlags := newListenerFlags("tcp", "set to determine how many tcp connections should be refused, "+
+		"the listener is closed for -tcp-refuse-window instead of accepting the failed connection")
+	var tcpRefuseWindow = flag.Duration("tcp-refuse-window", time.Second, "how long the tcp listener is "+
+		"closed for each failed connection")
+	udpFlags := newListenerFlags("udp", "set to determine how many udp packets should be dropped")
+	dnsFlags := newListenerFlags("dns", "set to determine how many dns queries should be answered with SERVFAIL")
+	var dnsNXDomainRate = flag.Int("dns-nxdomain-rate", 0, "set to determine how many dns queries should be "+
+		"answered with NXDOMAIN")
+	var dnsAnswer = flag.String("dns-answer", "127.0.0.1", "ipv4 address returned for every A query")
+	grpcFlags := newListenerFlags("grpc", "set to determine how many grpc health checks should "+
+		"report NOT_SERVING")
 	flag.Parse()
 
-	h := &http.Server{
-		Addr: *addr,
-		Handler: &artificialFailureHandler{
-			requestFailureRate: *requestFailureRate,
-			next: latencyHandler{
-				next: handler{},
-			},
+	var listeners []listener
+	if tcpFlags.Enabled() {
+		listeners = append(listeners, &tcpEchoServer{
+			addr:      *tcpFlags.addr,
+			profile:   tcpFlags.Profile(),
+			refuseFor: *tcpRefuseWindow,
+		})
+	}
+	if udpFlags.Enabled() {
+		listeners = append(listeners, &udpEchoServer{
+			addr:    *udpFlags.addr,
+			profile: udpFlags.Profile(),
+		})
+	}
+	if dnsFlags.Enabled() {
+		listeners = append(listeners, &dnsServer{
+			addr:     *dnsFlags.addr,
+			answer:   net.ParseIP(*dnsAnswer),
+			profile:  dnsFlags.Profile(),
+			nxdomain: newFailureCounter(*dnsNXDomainRate),
+		})
+	}
+	if grpcFlags.Enabled() {
+		listeners = append(listeners, &grpcHealthServer{
+			addr:    *grpcFlags.addr,
+			profile: grpcFlags.Profile(),
+		})
+	}
+	for _, l := range listeners {
+		go func(l listener) {
+			if err := l.ListenAndServe(); err != nil {
+				logger.Fatal(err)
+			}
+		}(l)
+	}
+
+	final, err := bodyFlags.Handler()
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	var root http.Handler = &artificialFailureHandler{
+		failures: newFailureCounter(*requestFailureRate),
+		next: latencyHandler{
+			next: final,
 		},
 	}
 
-	logger.Printf("starting test server on: %q\n", *addr)
+	if *routesConfig != "" {
+		conf, err := LoadRoutesConfig(*routesConfig)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		scheme := "http"
+		if tlsFlags.Enabled() {
+			scheme = "https"
+		}
+		root = conf.Mux(scheme + "://" + *addr)
+	}
+
+	h := &http.Server{
+		Addr:    *addr,
+		Handler: connFlags.Handler(root),
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	ln = connFlags.Listener(ln)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-signals
+
+		logger.Printf("received %s, draining for up to %s", sig, *shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, l := range listeners {
+			wg.Add(1)
+			go func(l listener) {
+				defer wg.Done()
+				if err := l.Shutdown(ctx); err != nil {
+					logger.Printf("error shutting down %T: %s", l, err)
+				}
+			}(l)
+		}
+		if err := h.Shutdown(ctx); err != nil {
+			logger.Printf("error shutting down: %s", err)
+		}
+		wg.Wait()
+	}()
 
-	if err := h.ListenAndServe(); err != nil {
-		logger.Println(err)
+	if tlsFlags.Enabled() {
+		if h.TLSConfig, err = tlsFlags.Config(); err != nil {
+			logger.Fatal(err)
+		}
+		connFlags.TLSConfig(h.TLSConfig)
+		logger.Printf("starting https test server on: %q\n", *addr)
+		err = h.ServeTLS(ln, "", "")
+	} else {
+		logger.Printf("starting test server on: %q\n", *addr)
+		err = h.Serve(ln)
 	}
 
+	if err != http.ErrServerClosed {
+		logger.Fatal(err)
+	}
+	<-drained
+	logger.Printf("shutdown complete")
 }
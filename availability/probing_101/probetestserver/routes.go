@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Downstream is a dependency a route calls before responding.  Either URL
+// (another probetestserver instance, or any http endpoint) or Route (the
+// name of a route on this instance) must be set.
+type Downstream struct {
+	URL     string `json:"url"`
+	Route   string `json:"route"`
+	Timeout string `json:"timeout"`
+}
+
+// Route describes a single named endpoint and its failure profile.
+type Route struct {
+	Name        string       `json:"name"`
+	Path        string       `json:"path"`
+	MinDuration string       `json:"min_duration"`
+	MaxDuration string       `json:"max_duration"`
+	FailureRate int          `json:"failure_rate"`
+	Downstreams []Downstream `json:"downstreams"`
+	Body        Body         `json:"body"`
+}
+
+// RoutesConfig is the top level document passed through -routes-config.
+type RoutesConfig struct {
+	Routes []Route `json:"routes"`
+}
+
+func LoadRoutesConfig(path string) (*RoutesConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := &RoutesConfig{}
+	if err := json.NewDecoder(f).Decode(conf); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	return conf, conf.Validate()
+}
+
+func (c *RoutesConfig) route(name string) (Route, bool) {
+	for _, r := range c.Routes {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+func (c *RoutesConfig) Validate() error {
+	if len(c.Routes) == 0 {
+		return fmt.Errorf("routes config must contain at least one route")
+	}
+
+	seen := map[string]bool{}
+	for _, r := range c.Routes {
+		if r.Name == "" || !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("route %+v requires a name and a path starting with '/'", r)
+		}
+		if seen[r.Path] {
+			return fmt.Errorf("duplicate route path %q", r.Path)
+		}
+		seen[r.Path] = true
+
+		minDuration, err := durationFromString(r.MinDuration)
+		if err != nil {
+			return fmt.Errorf("route %q: %s", r.Name, err)
+		}
+		maxDuration, err := durationFromString(r.MaxDuration)
+		if err != nil {
+			return fmt.Errorf("route %q: %s", r.Name, err)
+		}
+		if maxDuration < minDuration {
+			return fmt.Errorf("route %q: max_duration (%s) less than min_duration (%s)",
+				r.Name, maxDuration, minDuration)
+		}
+
+		if err := r.Body.Validate(); err != nil {
+			return fmt.Errorf("route %q: %s", r.Name, err)
+		}
+
+		for _, d := range r.Downstreams {
+			if (d.URL == "") == (d.Route == "") {
+				return fmt.Errorf("route %q: downstream %+v requires exactly one of url or route", r.Name, d)
+			}
+			if d.Route == r.Name {
+				return fmt.Errorf("route %q: cannot call itself", r.Name)
+			}
+			if _, ok := c.route(d.Route); d.Route != "" && !ok {
+				return fmt.Errorf("route %q: unknown downstream route %q", r.Name, d.Route)
+			}
+			timeout, err := durationFromString(d.Timeout)
+			if err != nil {
+				return fmt.Errorf("route %q: %s", r.Name, err)
+			}
+			if timeout < 0 {
+				return fmt.Errorf("route %q: downstream timeout must not be negative, received %q", r.Name, d.Timeout)
+			}
+		}
+	}
+	return c.checkCycles()
+}
+
+// checkCycles rejects routes that end up calling themselves through other
+// local routes, ie a -> b -> a, each call would make another over local
+// http until the timeouts fire, or forever without one.
+func (c *RoutesConfig) checkCycles() error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("downstream cycle: %s", strings.Join(append(path, name), " -> "))
+		case done:
+			return nil
+		}
+		state[name] = visiting
+
+		r, _ := c.route(name)
+		for _, d := range r.Downstreams {
+			if d.Route == "" {
+				continue
+			}
+			if err := visit(d.Route, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = done
+		return nil
+	}
+
+	for _, r := range c.Routes {
+		if err := visit(r.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mux builds a ServeMux with a handler chain per route.  baseURL is the
+// scheme and address this instance listens on, used to resolve downstreams
+// that reference local routes by name.
+func (c *RoutesConfig) Mux(baseURL string) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, r := range c.Routes {
+		// Validate has already checked the durations and body
+		minDuration, _ := durationFromString(r.MinDuration)
+		maxDuration, _ := durationFromString(r.MaxDuration)
+		final, _ := r.Body.Handler()
+
+		var next http.Handler = final
+		if len(r.Downstreams) > 0 {
+			next = c.dependencyHandler(r, baseURL, next)
+		}
+
+		mux.Handle(r.Path, &artificialFailureHandler{
+			failures: newFailureCounter(r.FailureRate),
+			next: latencyHandler{
+				minDuration: minDuration,
+				maxDuration: maxDuration,
+				next:        next,
+			},
+		})
+		logger.Printf("registered route %q at %q", r.Name, r.Path)
+	}
+	return mux
+}
+
+func (c *RoutesConfig) dependencyHandler(r Route, baseURL string, next http.Handler) http.Handler {
+	dh := dependencyHandler{
+		route: r.Name,
+		next:  next,
+	}
+	for _, d := range r.Downstreams {
+		timeout, _ := durationFromString(d.Timeout)
+		client := &http.Client{
+			Timeout: timeout,
+		}
+
+		url := d.URL
+		if d.Route != "" {
+			local, _ := c.route(d.Route)
+			url = baseURL + local.Path
+			// local calls shouldn't fail because of a simulated
+			// certificate problem, only the external probes should.
+			// Local calls are not supported in combination with mTLS.
+			client.Transport = &http.Transport{
+				TLSClientConfig: &tls.Config{
+					InsecureSkipVerify: true,
+				},
+			}
+		}
+		dh.downstreams = append(dh.downstreams, downstream{
+			url:    url,
+			client: client,
+		})
+	}
+	return dh
+}
+
+type downstream struct {
+	url    string
+	client *http.Client
+}
+
+// call is made with the incoming request's context so the downstream
+// chain is abandoned as soon as the caller gives up.
+func (d downstream) call(r *http.Request) error {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, d.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// dependencyHandler calls each downstream in order and fails the request
+// with a 502 as soon as one of them fails, the same way a service with a
+// hard dependency would.
+type dependencyHandler struct {
+	route       string
+	downstreams []downstream
+	next        http.Handler
+}
+
+func (dh dependencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Printf("dependencyHandler.ServeHTTP()")
+
+	for _, d := range dh.downstreams {
+		start := time.Now()
+		err := d.call(r)
+		logger.Printf("route %q called downstream %q in %s, err: %v",
+			dh.route, d.url, time.Since(start), err)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("downstream %q failed: %s", d.url, err), http.StatusBadGateway)
+			return
+		}
+	}
+
+	dh.next.ServeHTTP(w, r)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpEchoServer echoes back everything written to it.  A connection is
+// only refused if the refusal happens before the kernel completes the
+// handshake, so when the next connection is due to fail the listener is
+// closed for refuseFor.  Connections attempted in that window are refused
+// by the kernel, which a TCP probe observes as a failed connect, and count
+// as a single failure.  Connections already in the accept queue when the
+// listener closes are reset.
+//
+// The profile's latency delays the first echoed byte.  The handshake is
+// completed by the kernel before the server sees the connection, so the
+// latency is only observed by clients that send data, not by a probe that
+// only connects such as cloudprober's TCP probe.
+type tcpEchoServer struct {
+	addr      string
+	profile   faultProfile
+	refuseFor time.Duration
+
+	drain drain
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]bool
+}
+
+// listen opens the listener unless the server is shutting down, in which
+// case it returns nil.
+func (s *tcpEchoServer) listen() (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.drain.isClosing() {
+		return nil, nil
+	}
+	l, err := net.Listen("tcp", s.addr)
+	s.ln = l
+	return l, err
+}
+
+func (s *tcpEchoServer) ListenAndServe() error {
+	l, err := s.listen()
+	if err != nil || l == nil {
+		return err
+	}
+	logger.Printf("starting tcp echo server on: %q\n", s.addr)
+
+	for {
+		if s.profile.failures.NextFails() {
+			numConns, _ := s.profile.failures.Next()
+			logger.Printf("tcp: refusing connections for %s, connections count: %d w/ rate of %d",
+				s.refuseFor, numConns, s.profile.failures.rate)
+			l.Close()
+			time.Sleep(s.refuseFor)
+			if l, err = s.listen(); err != nil || l == nil {
+				return err
+			}
+			continue
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			if s.drain.isClosing() {
+				return nil
+			}
+			return err
+		}
+		s.profile.failures.Next()
+		if !s.drain.start() {
+			conn.Close()
+			return nil
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *tcpEchoServer) handle(conn net.Conn) {
+	defer s.drain.done()
+	s.track(conn, true)
+	defer s.track(conn, false)
+	defer conn.Close()
+
+	s.profile.Delay()
+
+	n, err := io.Copy(conn, conn)
+	logger.Printf("tcp: echoed %d bytes to %s, err: %v", n, conn.RemoteAddr(), err)
+}
+
+func (s *tcpEchoServer) track(conn net.Conn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = map[net.Conn]bool{}
+	}
+	if open {
+		s.conns[conn] = true
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// Shutdown closes the listener and waits for the echoing connections to
+// close, the connections still open when ctx is done are closed.
+func (s *tcpEchoServer) Shutdown(ctx context.Context) error {
+	s.drain.close()
+	s.mu.Lock()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.drain.wait(ctx)
+	if err != nil {
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+type tlsFlags struct {
+	certFile   *string
+	keyFile    *string
+	selfSigned *bool
+	expiresIn  *time.Duration
+	validFor   *time.Duration
+	hosts      *string
+	writeCert  *string
+	clientCA   *string
+}
+
+func newTLSFlags() tlsFlags {
+	return tlsFlags{
+		certFile: flag.String("tls-cert", "", "path to a PEM certificate, serves https when set along with -tls-key"),
+		keyFile:  flag.String("tls-key", "", "path to the PEM private key for -tls-cert"),
+		selfSigned: flag.Bool("tls-self-signed", false, "serves https with a self signed certificate "+
+			"generated at startup"),
+		expiresIn: flag.Duration("tls-cert-expires-in", 90*24*time.Hour, "time until the self signed "+
+			"certificate expires, a negative duration generates an already expired certificate"),
+		validFor: flag.Duration("tls-cert-valid-for", 365*24*time.Hour, "total validity window of the "+
+			"self signed certificate, ending at -tls-cert-expires-in"),
+		hosts: flag.String("tls-cert-hosts", "localhost,127.0.0.1", "comma separated dns names and ips "+
+			"the self signed certificate is valid for"),
+		writeCert: flag.String("tls-write-cert", "", "path to write the self signed certificate to, so "+
+			"probes can be configured to trust it"),
+		clientCA: flag.String("tls-client-ca", "", "path to a PEM CA bundle, when set clients must "+
+			"present a certificate signed by it (mTLS)"),
+	}
+}
+
+func (tf tlsFlags) Enabled() bool {
+	return *tf.selfSigned || *tf.certFile != ""
+}
+
+func (tf tlsFlags) Config() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+
+	switch {
+	case *tf.selfSigned && *tf.certFile != "":
+		return nil, fmt.Errorf("-tls-self-signed and -tls-cert are mutually exclusive")
+	case *tf.selfSigned:
+		if *tf.validFor <= 0 {
+			return nil, fmt.Errorf("-tls-cert-valid-for must be positive, received: %s", *tf.validFor)
+		}
+		notAfter := time.Now().Add(*tf.expiresIn)
+		var certPEM []byte
+		cert, certPEM, err = selfSignedCertificate(
+			strings.Split(*tf.hosts, ","), notAfter.Add(-*tf.validFor), notAfter)
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("generated self signed certificate valid from %s until %s",
+			notAfter.Add(-*tf.validFor).Format(time.RFC3339), notAfter.Format(time.RFC3339))
+		if *tf.writeCert != "" {
+			if err := ioutil.WriteFile(*tf.writeCert, certPEM, 0644); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		cert, err = tls.LoadX509KeyPair(*tf.certFile, *tf.keyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if *tf.clientCA != "" {
+		caPEM, err := ioutil.ReadFile(*tf.clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %q", *tf.clientCA)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return conf, nil
+}
+
+// selfSignedCertificate generates a certificate for hosts valid between
+// notBefore and notAfter.  The PEM encoded certificate is returned along
+// with the key pair.
+func selfSignedCertificate(hosts []string, notBefore time.Time, notAfter time.Time) (tls.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"probetestserver"},
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return cert, certPEM, err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// udpEchoServer echoes each datagram back to its sender.  Failed packets are
+// silently dropped, which is how packet loss looks to a UDP probe.
+type udpEchoServer struct {
+	addr    string
+	profile faultProfile
+
+	drain drain
+	mu    sync.Mutex
+	conn  net.PacketConn
+}
+
+func (s *udpEchoServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	logger.Printf("starting udp echo server on: %q\n", s.addr)
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.drain.isClosing() {
+				return nil
+			}
+			return err
+		}
+
+		if numPackets, drop := s.profile.failures.Next(); drop {
+			logger.Printf("udp: dropping packet from %s, packets count: %d w/ rate of %d",
+				addr, numPackets, s.profile.failures.rate)
+			continue
+		}
+
+		if !s.drain.start() {
+			continue
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		go func() {
+			defer s.drain.done()
+			s.profile.Delay()
+			if _, err := conn.WriteTo(packet, addr); err != nil {
+				logger.Printf("udp: error echoing to %s: %s", addr, err)
+			}
+		}()
+	}
+}
+
+// Shutdown drops new packets and waits for the delayed echoes to be sent
+// before closing the socket.
+func (s *udpEchoServer) Shutdown(ctx context.Context) error {
+	s.drain.close()
+	err := s.drain.wait(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package slo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Sample is a single row written by cloudprober's postgres surfacer.  Values
+// are cumulative from when the cloudprober process started.
+type Sample struct {
+	Time   time.Time
+	Metric string
+	Value  float64
+	Labels map[string]string
+}
+
+// Source returns the probe samples recorded since a point in time, ordered
+// by time.  A source can return fewer samples than were recorded as long
+// as each series' increase between them is preserved.
+type Source interface {
+	Samples(ctx context.Context, since time.Time) ([]Sample, error)
+}
+
+// probeMetrics are the metric names cloudprober writes for each probe
+// result.  The latency_* metrics are only written when the probe is
+// configured with a latency_distribution.
+var probeMetrics = []string{
+	"total",
+	"success",
+	"latency",
+	"latency_sum",
+	"latency_count",
+	"latency_bucket",
+}
+
+// AvailabilityMetrics are the probe metrics availability SLIs are computed
+// from, reading only them skips the much larger latency_bucket series.
+var AvailabilityMetrics = []string{"total", "success"}
+
+// Postgres reads samples from the table created by postgres-cloudprober.sh.
+// Metrics are the metric names read, every probe metric by default.  Rows
+// are aggregated to one sample per series every Resolution, a minute by
+// default, so a 30 day window doesn't load every probe result.
+type Postgres struct {
+	Metrics    []string
+	Resolution time.Duration
+
+	db    *sql.DB
+	table string
+}
+
+func NewPostgres(dbConnectionString string, table string) (*Postgres, error) {
+	db, err := sql.Open("postgres", dbConnectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return &Postgres{
+		Metrics:    probeMetrics,
+		Resolution: time.Minute,
+		db:         db,
+		table:      table,
+	}, nil
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+// Samples sums the increases of each series, handling cloudprober
+// restarts, into Resolution long buckets in postgres and returns the
+// running total at the last sample of each bucket.  The returned values
+// are cumulative from since instead of from when cloudprober started.
+func (p *Postgres) Samples(ctx context.Context, since time.Time) ([]Sample, error) {
+	if p.Resolution <= 0 {
+		return nil, fmt.Errorf("resolution must be > 0, received: %s", p.Resolution)
+	}
+
+	// the table name can't be a query parameter, quote it as an identifier
+	q := fmt.Sprintf(`
+		WITH deltas AS (
+			SELECT time, metric_name, labels,
+				CASE
+					WHEN lag(value) OVER series IS NULL THEN 0
+					WHEN value >= lag(value) OVER series THEN value - lag(value) OVER series
+					ELSE value
+				END AS delta
+			FROM %s
+			WHERE time >= $1 AND metric_name = ANY($2)
+			WINDOW series AS (PARTITION BY metric_name, labels ORDER BY time)
+		), buckets AS (
+			SELECT max(time) AS time, metric_name, labels, sum(delta) AS delta
+			FROM deltas
+			GROUP BY metric_name, labels, floor(extract(epoch FROM time) / $3)
+		)
+		SELECT time, metric_name,
+			sum(delta) OVER (PARTITION BY metric_name, labels ORDER BY time) AS value,
+			labels
+		FROM buckets
+		ORDER BY time`, pq.QuoteIdentifier(p.table))
+
+	rows, err := p.db.QueryContext(ctx, q, since, pq.Array(p.Metrics), p.Resolution.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []Sample{}
+	for rows.Next() {
+		s := Sample{}
+		var labels []byte
+
+		if err := rows.Scan(&s.Time, &s.Metric, &s.Value, &labels); err != nil {
+			return samples, err
+		}
+		if err := json.Unmarshal(labels, &s.Labels); err != nil {
+			return samples, fmt.Errorf("decoding labels %q: %s", labels, err)
+		}
+
+		samples = append(samples, s)
+	}
+
+	return samples, rows.Err()
+}
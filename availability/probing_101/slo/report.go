@@ -0,0 +1,188 @@
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// WindowReport is the SLIs of a target over one rolling window.  Values
+// that can't be computed, ie latency without a latency_distribution, are
+// omitted.
+type WindowReport struct {
+	Window               string   `json:"window"`
+	Total                float64  `json:"total"`
+	Success              float64  `json:"success"`
+	Availability         *float64 `json:"availability,omitempty"`
+	AvailabilityBurnRate *float64 `json:"availability_burn_rate,omitempty"`
+	Latency              *float64 `json:"latency,omitempty"`
+	LatencyBurnRate      *float64 `json:"latency_burn_rate,omitempty"`
+	MeanLatencySeconds   *float64 `json:"mean_latency_seconds,omitempty"`
+}
+
+type TargetReport struct {
+	Target
+	// LatencyBucket is the latency_bucket boundary used for the latency
+	// SLI, in the probe's latency_unit
+	LatencyBucket               string         `json:"latency_bucket,omitempty"`
+	AvailabilityBudgetRemaining *float64       `json:"availability_budget_remaining,omitempty"`
+	LatencyBudgetRemaining      *float64       `json:"latency_budget_remaining,omitempty"`
+	Windows                     []WindowReport `json:"windows"`
+}
+
+type ObjectiveReport struct {
+	Availability            float64 `json:"availability"`
+	Latency                 float64 `json:"latency"`
+	LatencyThresholdSeconds float64 `json:"latency_threshold_seconds"`
+	Period                  string  `json:"period"`
+}
+
+type Report struct {
+	Generated time.Time       `json:"generated"`
+	Objective ObjectiveReport `json:"objective"`
+	Targets   []TargetReport  `json:"targets"`
+}
+
+func float(f float64) *float64 {
+	return &f
+}
+
+// NewReport computes the SLIs of every target over each of windows ending
+// at end.  The error budget remaining is computed over the objective's
+// Period.
+func NewReport(samples []Sample, end time.Time, windows []time.Duration, o Objective) *Report {
+	report := &Report{
+		Generated: end,
+		Objective: ObjectiveReport{
+			Availability:            o.Availability,
+			Latency:                 o.Latency,
+			LatencyThresholdSeconds: o.LatencyThreshold.Seconds(),
+			Period:                  o.Period.String(),
+		},
+	}
+
+	byTarget := map[Target]*TargetReport{}
+	targetReport := func(t Target) *TargetReport {
+		tr, ok := byTarget[t]
+		if !ok {
+			tr = &TargetReport{
+				Target: t,
+			}
+			byTarget[t] = tr
+		}
+		return tr
+	}
+
+	for _, window := range windows {
+		for t, sli := range Compute(samples, end, window, o) {
+			tr := targetReport(t)
+			wr := WindowReport{
+				Window:  window.String(),
+				Total:   sli.Total,
+				Success: sli.Success,
+			}
+			if a, ok := sli.Availability(); ok {
+				wr.Availability = float(a)
+				wr.AvailabilityBurnRate = float(BurnRate(a, o.Availability))
+			}
+			if l, ok := sli.LatencyRatio(); ok {
+				wr.Latency = float(l)
+				wr.LatencyBurnRate = float(BurnRate(l, o.Latency))
+				tr.LatencyBucket = sli.LatencyBucket
+			}
+			if m, ok := sli.MeanLatency(o.LatencyUnit); ok {
+				wr.MeanLatencySeconds = float(m.Seconds())
+			}
+			tr.Windows = append(tr.Windows, wr)
+		}
+	}
+
+	for t, sli := range Compute(samples, end, o.Period, o) {
+		tr := targetReport(t)
+		if a, ok := sli.Availability(); ok {
+			tr.AvailabilityBudgetRemaining = float(1 - BurnRate(a, o.Availability))
+		}
+		if l, ok := sli.LatencyRatio(); ok {
+			tr.LatencyBudgetRemaining = float(1 - BurnRate(l, o.Latency))
+		}
+	}
+
+	for _, tr := range byTarget {
+		report.Targets = append(report.Targets, *tr)
+	}
+	sort.Slice(report.Targets, func(i, j int) bool {
+		a, b := report.Targets[i], report.Targets[j]
+		if a.Probe != b.Probe {
+			return a.Probe < b.Probe
+		}
+		return a.Dst < b.Dst
+	})
+
+	return report
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func percent(f *float64) string {
+	if f == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.3f%%", *f*100)
+}
+
+func ratio(f *float64) string {
+	if f == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", *f)
+}
+
+func seconds(f *float64) string {
+	if f == nil {
+		return "-"
+	}
+	return time.Duration(*f * float64(time.Second)).Round(time.Microsecond).String()
+}
+
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "SLO report generated %s\n", r.Generated.Format(time.RFC3339))
+	fmt.Fprintf(w, "objective: availability %s, latency %s under %s, period %s\n\n",
+		percent(&r.Objective.Availability), percent(&r.Objective.Latency),
+		seconds(&r.Objective.LatencyThresholdSeconds), r.Objective.Period)
+
+	if len(r.Targets) == 0 {
+		fmt.Fprintln(w, "no probe results found")
+		return nil
+	}
+
+	for _, t := range r.Targets {
+		fmt.Fprintf(w, "probe=%q target=%q\n", t.Probe, t.Dst)
+		fmt.Fprintf(w, "  error budget remaining: availability %s, latency %s\n",
+			percent(t.AvailabilityBudgetRemaining), percent(t.LatencyBudgetRemaining))
+		if t.LatencyBucket == "" {
+			fmt.Fprintln(w, "  latency SLI unavailable, configure a latency_distribution on the probe")
+		}
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  window\ttotal\tsuccess\tavailability\tburn rate\tlatency\tburn rate\tmean latency\t")
+		for _, wr := range t.Windows {
+			fmt.Fprintf(tw, "  %s\t%.0f\t%.0f\t%s\t%s\t%s\t%s\t%s\t\n",
+				wr.Window, wr.Total, wr.Success,
+				percent(wr.Availability), ratio(wr.AvailabilityBurnRate),
+				percent(wr.Latency), ratio(wr.LatencyBurnRate),
+				seconds(wr.MeanLatencySeconds))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package slo
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Objective is the SLO the probe results are measured against.
+type Objective struct {
+	// Availability is the target ratio of successful probes, ie 0.999
+	Availability float64
+	// Latency is the target ratio of successful probes faster than
+	// LatencyThreshold, ie 0.95
+	Latency          float64
+	LatencyThreshold time.Duration
+	// LatencyUnit is the probe's cloudprober latency_unit, cloudprober
+	// defaults to microseconds
+	LatencyUnit time.Duration
+	// Period is the window the error budget is spent over
+	Period time.Duration
+}
+
+// BurnRate is how many times faster than allowed by objective the error
+// budget is being spent at the measured sli.  A burn rate of 1 spends
+// exactly the whole budget over the SLO period.
+func BurnRate(sli float64, objective float64) float64 {
+	return (1 - sli) / (1 - objective)
+}
+
+// Target identifies a single probe/target pair, the granularity SLIs are
+// computed at.
+type Target struct {
+	Probe string `json:"probe"`
+	Dst   string `json:"target"`
+}
+
+func targetFromLabels(labels map[string]string) Target {
+	return Target{
+		Probe: labels["probe"],
+		Dst:   labels["dst"],
+	}
+}
+
+// counter accumulates the increase of one of cloudprober's cumulative
+// counters.  A decrease means cloudprober restarted and started counting
+// from 0 again.
+type counter struct {
+	last     float64
+	seen     bool
+	increase float64
+}
+
+func (c *counter) add(v float64) {
+	if c.seen {
+		if v >= c.last {
+			c.increase += v - c.last
+		} else {
+			c.increase += v
+		}
+	}
+	c.last = v
+	c.seen = true
+}
+
+// SLI holds the counter increases for a single target over a window.
+type SLI struct {
+	Window time.Duration
+
+	Total   float64
+	Success float64
+
+	// LatencyBucket is the upper bound of the latency_bucket used as the
+	// latency threshold, in the probe's latency_unit.  It is empty when
+	// the probe has no latency_distribution.
+	LatencyBucket string
+	LatencyGood   float64
+	LatencyCount  float64
+
+	// LatencySum is the total latency of LatencySumCount successful
+	// probes, in the probe's latency_unit
+	LatencySum      float64
+	LatencySumCount float64
+}
+
+// Availability returns the ratio of successful probes, false when there
+// were no probes in the window.
+func (s SLI) Availability() (float64, bool) {
+	if s.Total == 0 {
+		return 0, false
+	}
+	return s.Success / s.Total, true
+}
+
+// LatencyRatio returns the ratio of successful probes faster than the
+// objective's threshold, false when it can't be computed.
+func (s SLI) LatencyRatio() (float64, bool) {
+	if s.LatencyBucket == "" || s.LatencyCount == 0 {
+		return 0, false
+	}
+	return s.LatencyGood / s.LatencyCount, true
+}
+
+// MeanLatency returns the average latency of successful probes.
+func (s SLI) MeanLatency(unit time.Duration) (time.Duration, bool) {
+	if s.LatencySumCount == 0 {
+		return 0, false
+	}
+	return time.Duration(s.LatencySum / s.LatencySumCount * float64(unit)), true
+}
+
+// Compute returns the SLI of every target for the window ending at end.
+// samples must be ordered by time.
+func Compute(samples []Sample, end time.Time, window time.Duration, o Objective) map[Target]*SLI {
+	start := end.Add(-window)
+
+	type targetCounters struct {
+		total, success, latency, sum, count counter
+		buckets                             map[string]*counter
+	}
+	counters := map[Target]*targetCounters{}
+
+	for _, s := range samples {
+		if s.Time.Before(start) || s.Time.After(end) {
+			continue
+		}
+
+		t := targetFromLabels(s.Labels)
+		tc, ok := counters[t]
+		if !ok {
+			tc = &targetCounters{
+				buckets: map[string]*counter{},
+			}
+			counters[t] = tc
+		}
+
+		switch s.Metric {
+		case "total":
+			tc.total.add(s.Value)
+		case "success":
+			tc.success.add(s.Value)
+		case "latency":
+			tc.latency.add(s.Value)
+		case "latency_sum":
+			tc.sum.add(s.Value)
+		case "latency_count":
+			tc.count.add(s.Value)
+		case "latency_bucket":
+			le := s.Labels["le"]
+			if _, ok := tc.buckets[le]; !ok {
+				tc.buckets[le] = &counter{}
+			}
+			tc.buckets[le].add(s.Value)
+		}
+	}
+
+	threshold := float64(o.LatencyThreshold) / float64(o.LatencyUnit)
+
+	slis := map[Target]*SLI{}
+	for t, tc := range counters {
+		sli := &SLI{
+			Window:  window,
+			Total:   tc.total.increase,
+			Success: tc.success.increase,
+		}
+
+		if tc.count.seen {
+			// latency_distribution configured
+			sli.LatencySum = tc.sum.increase
+			sli.LatencySumCount = tc.count.increase
+			if le, ok := thresholdBucket(tc.buckets, threshold); ok {
+				sli.LatencyBucket = le
+				sli.LatencyGood = tc.buckets[le].increase
+				sli.LatencyCount = tc.count.increase
+			}
+		} else {
+			// latency is the cumulative latency of successful probes
+			sli.LatencySum = tc.latency.increase
+			sli.LatencySumCount = tc.success.increase
+		}
+
+		slis[t] = sli
+	}
+	return slis
+}
+
+// thresholdBucket returns the largest bucket boundary that is less than or
+// equal to threshold.  Using a smaller bucket than the threshold undercounts
+// good probes so the reported SLI errs on the side of being pessimistic.
+func thresholdBucket(buckets map[string]*counter, threshold float64) (string, bool) {
+	type bound struct {
+		le    string
+		value float64
+	}
+	bounds := []bound{}
+	for le := range buckets {
+		v, err := strconv.ParseFloat(le, 64)
+		if err != nil || math.IsInf(v, 1) {
+			continue
+		}
+		bounds = append(bounds, bound{le, v})
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].value < bounds[j].value })
+
+	best, found := "", false
+	for _, b := range bounds {
+		// tolerate float formatting differences, ie .1 vs 0.1
+		if b.value <= threshold*(1+1e-9) {
+			best, found = b.le, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,162 @@
+package slogen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type target struct {
+	Expr         string `json:"expr"`
+	Format       string `json:"format"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+type axis struct {
+	Format string   `json:"format"`
+	Show   bool     `json:"show"`
+	Min    *float64 `json:"min"`
+	Max    *float64 `json:"max"`
+}
+
+type legend struct {
+	Show bool `json:"show"`
+}
+
+type xaxis struct {
+	Mode string `json:"mode"`
+	Show bool   `json:"show"`
+}
+
+type panel struct {
+	Datasource string   `json:"datasource"`
+	GridPos    gridPos  `json:"gridPos"`
+	ID         int      `json:"id"`
+	Title      string   `json:"title"`
+	Type       string   `json:"type"`
+	Fill       int      `json:"fill"`
+	Lines      bool     `json:"lines"`
+	Linewidth  int      `json:"linewidth"`
+	Legend     legend   `json:"legend"`
+	Targets    []target `json:"targets"`
+	Xaxis      xaxis    `json:"xaxis"`
+	Yaxes      []axis   `json:"yaxes"`
+}
+
+type dashboard struct {
+	Editable      bool              `json:"editable"`
+	Panels        []panel           `json:"panels"`
+	Refresh       string            `json:"refresh"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Tags          []string          `json:"tags"`
+	Time          map[string]string `json:"time"`
+	Timezone      string            `json:"timezone"`
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Version       int               `json:"version"`
+}
+
+func graph(datasource string, title string, format string, targets ...target) panel {
+	for i := range targets {
+		targets[i].Format = "time_series"
+		targets[i].RefID = string(rune('A' + i))
+	}
+	return panel{
+		Datasource: datasource,
+		Title:      title,
+		Type:       "graph",
+		Fill:       1,
+		Lines:      true,
+		Linewidth:  1,
+		Legend:     legend{Show: true},
+		Targets:    targets,
+		Xaxis:      xaxis{Mode: "time", Show: true},
+		Yaxes: []axis{
+			{Format: format, Show: true},
+			{Format: "short", Show: false},
+		},
+	}
+}
+
+// Dashboard builds a grafana dashboard with the SLI, error budget remaining
+// and burn rates of every SLO, backed by the generated recording rules.
+func (s *Spec) Dashboard(datasource string) ([]byte, error) {
+	d := dashboard{
+		Editable:      true,
+		Refresh:       "1m",
+		SchemaVersion: 16,
+		Tags:          []string{"slo", s.Service},
+		Time:          map[string]string{"from": "now-24h", "to": "now"},
+		Timezone:      "browser",
+		Title:         fmt.Sprintf("%s SLOs", s.Service),
+		UID:           "slo-" + s.Service,
+		Version:       1,
+	}
+
+	id := 1
+	y := 0
+	for _, slo := range s.SLOs {
+		sel := s.selector(slo)
+
+		sli := []target{}
+		for _, w := range slo.Windows() {
+			sli = append(sli, target{
+				Expr:         fmt.Sprintf("1 - %s%s", ErrorRatioMetric(w), sel),
+				LegendFormat: "sli " + w,
+			})
+		}
+		sli = append(sli, target{
+			Expr:         "slo:objective:ratio" + sel,
+			LegendFormat: "objective",
+		})
+
+		burn := []target{}
+		for _, a := range slo.Alerts {
+			for _, w := range []string{a.ShortWindow, a.LongWindow} {
+				burn = append(burn, target{
+					Expr:         fmt.Sprintf("%s%s / %g", ErrorRatioMetric(w), sel, slo.ErrorBudget()),
+					LegendFormat: fmt.Sprintf("%s (%s %gx)", w, a.Severity, a.BurnRate),
+				})
+			}
+		}
+
+		panels := []panel{
+			graph(datasource, fmt.Sprintf("%s SLI (objective %g)", slo.Name, slo.Objective), "percentunit", sli...),
+			graph(datasource, fmt.Sprintf("%s error budget remaining (%s)", slo.Name, slo.Period), "percentunit",
+				target{
+					Expr:         "slo:error_budget_remaining:ratio" + sel,
+					LegendFormat: "budget remaining",
+				}),
+			graph(datasource, fmt.Sprintf("%s burn rate", slo.Name), "short", burn...),
+		}
+
+		x := 0
+		for _, p := range panels {
+			p.ID = id
+			p.GridPos = gridPos{H: 7, W: 8, X: x, Y: y}
+			d.Panels = append(d.Panels, p)
+			id += 1
+			x += 8
+		}
+		y += 7
+	}
+
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func (s *Spec) WriteDashboard(w io.Writer, datasource string) error {
+	b, err := s.Dashboard(datasource)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
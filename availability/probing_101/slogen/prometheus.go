@@ -0,0 +1,166 @@
+package slogen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const alertName = "SLOErrorBudgetBurn"
+
+// Rule is either a recording or an alerting rule, mirroring a rule in a
+// prometheus rule file.
+type Rule struct {
+	Record      string
+	Alert       string
+	Expr        string
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+type RuleGroup struct {
+	Name  string
+	Rules []Rule
+}
+
+// ErrorRatioMetric is the recorded error ratio over window.
+func ErrorRatioMetric(window string) string {
+	return "slo:sli_error:ratio_rate" + window
+}
+
+func (s *Spec) selector(slo SLO) string {
+	return fmt.Sprintf(`{service=%q, slo=%q}`, s.Service, slo.Name)
+}
+
+func (s *Spec) labels(slo SLO) map[string]string {
+	return map[string]string{
+		"service": s.Service,
+		"slo":     slo.Name,
+	}
+}
+
+// RuleGroups generates a group per SLO with an error ratio recording rule
+// per window, the objective and budget remaining, and a multiwindow,
+// multi-burn-rate alert per severity.
+func (s *Spec) RuleGroups() ([]RuleGroup, error) {
+	groups := []RuleGroup{}
+	for _, slo := range s.SLOs {
+		g := RuleGroup{
+			Name: fmt.Sprintf("slo:%s:%s", s.Service, slo.Name),
+		}
+
+		for _, w := range slo.Windows() {
+			expr, err := slo.ErrorRatio(w)
+			if err != nil {
+				return nil, err
+			}
+			g.Rules = append(g.Rules, Rule{
+				Record: ErrorRatioMetric(w),
+				Expr:   expr,
+				Labels: s.labels(slo),
+			})
+		}
+
+		g.Rules = append(g.Rules,
+			Rule{
+				Record: "slo:objective:ratio",
+				Expr:   fmt.Sprintf("vector(%g)", slo.Objective),
+				Labels: s.labels(slo),
+			},
+			Rule{
+				Record: "slo:error_budget_remaining:ratio",
+				Expr: fmt.Sprintf("1 - %s%s / %g",
+					ErrorRatioMetric(slo.Period), s.selector(slo), slo.ErrorBudget()),
+				Labels: s.labels(slo),
+			},
+		)
+
+		bySeverity := map[string][]BurnRateAlert{}
+		severities := []string{}
+		for _, a := range slo.Alerts {
+			if _, ok := bySeverity[a.Severity]; !ok {
+				severities = append(severities, a.Severity)
+			}
+			bySeverity[a.Severity] = append(bySeverity[a.Severity], a)
+		}
+
+		for _, severity := range severities {
+			conditions := []string{}
+			for _, a := range bySeverity[severity] {
+				threshold := fmt.Sprintf("(%g * %g)", a.BurnRate, slo.ErrorBudget())
+				conditions = append(conditions, fmt.Sprintf("(\n  %s%s > %s\n  and\n  %s%s > %s\n)",
+					ErrorRatioMetric(a.LongWindow), s.selector(slo), threshold,
+					ErrorRatioMetric(a.ShortWindow), s.selector(slo), threshold))
+			}
+
+			labels := s.labels(slo)
+			labels["severity"] = severity
+			g.Rules = append(g.Rules, Rule{
+				Alert:  alertName,
+				Expr:   strings.Join(conditions, "\nor\n"),
+				Labels: labels,
+				Annotations: map[string]string{
+					"summary": fmt.Sprintf("%s %s SLO is burning its error budget too fast",
+						s.Service, slo.Name),
+					"description": fmt.Sprintf("%s objective: %g over %s. %s",
+						slo.Name, slo.Objective, slo.Period, slo.Description),
+				},
+			})
+		}
+
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// yamlString quotes s as a yaml double quoted scalar, the escapes go uses
+// are a subset of yaml's.
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
+
+func writeMap(w io.Writer, indent string, key string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "%s%s:\n", indent, key)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s  %s: %s\n", indent, k, yamlString(m[k]))
+	}
+}
+
+// WriteRules writes groups as a prometheus rule file.
+func WriteRules(out io.Writer, groups []RuleGroup) error {
+	w := &bytes.Buffer{}
+	fmt.Fprintln(w, "# Generated by cmd/slogen, do not edit.")
+	fmt.Fprintln(w, "groups:")
+	for _, g := range groups {
+		fmt.Fprintf(w, "- name: %s\n", yamlString(g.Name))
+		fmt.Fprintln(w, "  rules:")
+		for _, r := range g.Rules {
+			if r.Record != "" {
+				fmt.Fprintf(w, "  - record: %s\n", yamlString(r.Record))
+			} else {
+				fmt.Fprintf(w, "  - alert: %s\n", yamlString(r.Alert))
+			}
+			fmt.Fprintln(w, "    expr: |")
+			for _, line := range strings.Split(r.Expr, "\n") {
+				fmt.Fprintf(w, "      %s\n", line)
+			}
+			writeMap(w, "    ", "labels", r.Labels)
+			writeMap(w, "    ", "annotations", r.Annotations)
+		}
+	}
+
+	_, err := w.WriteTo(out)
+	return err
+}
@@ -0,0 +1,201 @@
+package slogen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// promDuration matches the single unit durations used in PromQL range
+// selectors, ie 5m, 6h, 30d.
+var promDuration = regexp.MustCompile(`^[0-9]+[smhdwy]$`)
+
+// SLI is a ratio query.  Exactly one of ErrorQuery or GoodQuery is set
+// along with TotalQuery.  Each query is a go template with {{.Window}} in
+// place of the range selector's duration, ie:
+//
+//	sum(rate(success{probe="test_server"}[{{.Window}}]))
+type SLI struct {
+	ErrorQuery string `json:"error_query"`
+	GoodQuery  string `json:"good_query"`
+	TotalQuery string `json:"total_query"`
+}
+
+// BurnRateAlert fires when the error budget is burning BurnRate times
+// faster than the objective allows over both windows.
+type BurnRateAlert struct {
+	Severity    string  `json:"severity"`
+	LongWindow  string  `json:"long_window"`
+	ShortWindow string  `json:"short_window"`
+	BurnRate    float64 `json:"burn_rate"`
+}
+
+// DefaultAlerts are the SRE workbook's multiwindow, multi-burn-rate alerts
+// for a 30 day SLO.
+var DefaultAlerts = []BurnRateAlert{
+	{Severity: "page", LongWindow: "1h", ShortWindow: "5m", BurnRate: 14.4},
+	{Severity: "page", LongWindow: "6h", ShortWindow: "30m", BurnRate: 6},
+	{Severity: "ticket", LongWindow: "1d", ShortWindow: "2h", BurnRate: 3},
+	{Severity: "ticket", LongWindow: "3d", ShortWindow: "6h", BurnRate: 1},
+}
+
+type SLO struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Objective   float64         `json:"objective"`
+	Period      string          `json:"period"`
+	SLI         SLI             `json:"sli"`
+	Alerts      []BurnRateAlert `json:"alerts"`
+}
+
+// Spec is the declarative SLO definition of a single service.
+type Spec struct {
+	Service string `json:"service"`
+	SLOs    []SLO  `json:"slos"`
+}
+
+func LoadSpec(path string) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	spec := &Spec{}
+	if err := json.NewDecoder(f).Decode(spec); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	spec.setDefaults()
+	return spec, spec.Validate()
+}
+
+func (s *Spec) setDefaults() {
+	for i := range s.SLOs {
+		if s.SLOs[i].Period == "" {
+			s.SLOs[i].Period = "30d"
+		}
+		if len(s.SLOs[i].Alerts) == 0 {
+			s.SLOs[i].Alerts = DefaultAlerts
+		}
+	}
+}
+
+func (s *Spec) Validate() error {
+	if s.Service == "" {
+		return fmt.Errorf("service is required")
+	}
+	if len(s.SLOs) == 0 {
+		return fmt.Errorf("at least one slo is required")
+	}
+
+	seen := map[string]bool{}
+	for _, slo := range s.SLOs {
+		if slo.Name == "" || seen[slo.Name] {
+			return fmt.Errorf("each slo requires a unique name, received: %q", slo.Name)
+		}
+		seen[slo.Name] = true
+
+		if slo.Objective <= 0 || slo.Objective >= 1 {
+			return fmt.Errorf("slo %q: objective must be between 0 and 1", slo.Name)
+		}
+		if (slo.SLI.ErrorQuery == "") == (slo.SLI.GoodQuery == "") || slo.SLI.TotalQuery == "" {
+			return fmt.Errorf("slo %q: sli requires total_query and exactly one of error_query or good_query",
+				slo.Name)
+		}
+		if _, err := slo.ErrorRatio("5m"); err != nil {
+			return fmt.Errorf("slo %q: %s", slo.Name, err)
+		}
+
+		if !promDuration.MatchString(slo.Period) {
+			return fmt.Errorf("slo %q: invalid prometheus duration %q", slo.Name, slo.Period)
+		}
+		for _, a := range slo.Alerts {
+			for _, w := range []string{a.LongWindow, a.ShortWindow} {
+				if !promDuration.MatchString(w) {
+					return fmt.Errorf("slo %q: invalid prometheus duration %q", slo.Name, w)
+				}
+			}
+			if a.Severity == "" || a.BurnRate <= 0 {
+				return fmt.Errorf("slo %q: alerts require a severity and a positive burn_rate", slo.Name)
+			}
+			if seconds(a.ShortWindow) >= seconds(a.LongWindow) {
+				return fmt.Errorf("slo %q: short_window %q must be less than long_window %q",
+					slo.Name, a.ShortWindow, a.LongWindow)
+			}
+		}
+	}
+	return nil
+}
+
+// seconds converts a validated prometheus duration to seconds, for ordering.
+func seconds(d string) float64 {
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	var n float64
+	fmt.Sscanf(d[:len(d)-1], "%g", &n)
+	return n * units[d[len(d)-1]].Seconds()
+}
+
+// ErrorBudget is the ratio of errors the objective allows.
+func (s SLO) ErrorBudget() float64 {
+	// round away float error, 1 - 0.99 is 0.010000000000000009
+	return math.Round((1-s.Objective)*1e9) / 1e9
+}
+
+// Windows returns every window a recording rule is needed for, shortest
+// first.
+func (s SLO) Windows() []string {
+	set := map[string]bool{
+		s.Period: true,
+	}
+	for _, a := range s.Alerts {
+		set[a.LongWindow] = true
+		set[a.ShortWindow] = true
+	}
+
+	windows := []string{}
+	for w := range set {
+		windows = append(windows, w)
+	}
+	sort.Slice(windows, func(i, j int) bool { return seconds(windows[i]) < seconds(windows[j]) })
+	return windows
+}
+
+func renderQuery(q string, window string) (string, error) {
+	t, err := template.New("query").Parse(q)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, struct{ Window string }{window})
+	return strings.TrimSpace(buf.String()), err
+}
+
+// ErrorRatio renders the ratio of bad events over window.
+func (s SLO) ErrorRatio(window string) (string, error) {
+	total, err := renderQuery(s.SLI.TotalQuery, window)
+	if err != nil {
+		return "", err
+	}
+
+	if s.SLI.ErrorQuery != "" {
+		errs, err := renderQuery(s.SLI.ErrorQuery, window)
+		return fmt.Sprintf("(%s)\n/\n(%s)", errs, total), err
+	}
+
+	good, err := renderQuery(s.SLI.GoodQuery, window)
+	return fmt.Sprintf("1 - (\n  (%s)\n  /\n  (%s)\n)", good, total), err
+}
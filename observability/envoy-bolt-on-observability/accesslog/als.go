@@ -0,0 +1,128 @@
+package accesslog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	grpcCodeOK            = 0
+	grpcCodeInvalid       = 3
+	grpcCodeExhausted     = 8
+	grpcCodeUnimplemented = 12
+
+	// maxMessageSize is grpc's default limit of received messages, the
+	// length prefix is read before anything else of the message so it
+	// bounds what a client can make the receiver allocate.
+	maxMessageSize = 4 << 20
+)
+
+var errMessageTooLarge = errors.New("grpc message is larger than the 4MiB limit")
+
+// StreamAccessLogs is the method of both the v2 and v3 access log service.
+var streamAccessLogsPaths = map[string]bool{
+	"/envoy.service.accesslog.v2.AccessLogService/StreamAccessLogs": true,
+	"/envoy.service.accesslog.v3.AccessLogService/StreamAccessLogs": true,
+}
+
+// ALSServer implements envoy's grpc access log service.  Envoy opens a
+// single client stream per log and keeps sending StreamAccessLogsMessages
+// on it, the server only responds when the stream is closed.  It's served
+// over cleartext HTTP/2 with the standard library, see grpc.go in
+// probetestserver.
+type ALSServer struct {
+	recorder *Recorder
+}
+
+func NewALSServer(recorder *Recorder) *ALSServer {
+	return &ALSServer{
+		recorder: recorder,
+	}
+}
+
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", msg)
+}
+
+// readMessage reads a single length prefixed grpc message, it returns
+// io.EOF when the client closed the stream between messages.
+func readMessage(body io.Reader) ([]byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(body, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, fmt.Errorf("compressed grpc messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxMessageSize {
+		return nil, errMessageTooLarge
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, fmt.Errorf("reading grpc message: %s", err)
+	}
+	return msg, nil
+}
+
+func (s *ALSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+	if r.Method != http.MethodPost || !streamAccessLogsPaths[r.URL.Path] {
+		writeGRPCStatus(w, grpcCodeUnimplemented, fmt.Sprintf("unknown method %q", r.URL.Path))
+		return
+	}
+
+	logName := ""
+	for {
+		msg, err := readMessage(r.Body)
+		if err == io.EOF {
+			break
+		}
+		if err == errMessageTooLarge {
+			writeGRPCStatus(w, grpcCodeExhausted, err.Error())
+			return
+		}
+		if err != nil {
+			writeGRPCStatus(w, grpcCodeInvalid, err.Error())
+			return
+		}
+
+		entries, err := DecodeStreamMessage(msg, &logName)
+		if err != nil {
+			decodeErrors.WithLabelValues("grpc").Inc()
+			log.Printf("decoding StreamAccessLogsMessage: %s", err)
+			continue
+		}
+		for _, e := range entries {
+			s.recorder.Record(e)
+		}
+	}
+
+	// StreamAccessLogsResponse is empty
+	w.Write([]byte{0, 0, 0, 0, 0})
+	writeGRPCStatus(w, grpcCodeOK, "")
+}
+
+func (s *ALSServer) ListenAndServe(addr string) error {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+
+	h := &http.Server{
+		Addr:      addr,
+		Handler:   s,
+		Protocols: &protocols,
+	}
+	return h.ListenAndServe()
+}
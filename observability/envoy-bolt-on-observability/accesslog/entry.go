@@ -0,0 +1,272 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a single http request, normalized from either envoy's grpc
+// access log service or a json access log line.
+type Entry struct {
+	LogName          string        `json:"log_name,omitempty"`
+	StartTime        time.Time     `json:"start_time"`
+	Route            string        `json:"route"`
+	UpstreamCluster  string        `json:"upstream_cluster"`
+	UpstreamHost     string        `json:"upstream_host"`
+	Method           string        `json:"method"`
+	Authority        string        `json:"authority,omitempty"`
+	Path             string        `json:"path"`
+	RequestID        string        `json:"request_id,omitempty"`
+	Status           int           `json:"response_code"`
+	ResponseFlags    string        `json:"response_flags,omitempty"`
+	BytesReceived    uint64        `json:"bytes_received"`
+	BytesSent        uint64        `json:"bytes_sent"`
+	Duration         time.Duration `json:"-"`
+	UpstreamDuration time.Duration `json:"-"`
+}
+
+// MarshalJSON writes the durations in milliseconds, like the access logs
+// they came from.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		entry
+		DurationMS         float64 `json:"duration_ms"`
+		UpstreamDurationMS float64 `json:"upstream_duration_ms"`
+	}{
+		entry:              entry(e),
+		DurationMS:         e.Duration.Seconds() * 1000,
+		UpstreamDurationMS: e.UpstreamDuration.Seconds() * 1000,
+	})
+}
+
+// Failed is true for 5xx responses and for requests envoy couldn't
+// complete, which are logged with response flags and possibly no status.
+func (e Entry) Failed() bool {
+	return e.Status >= 500 || e.Status == 0 || e.ResponseFlags != ""
+}
+
+// envoy.api.v2.core.RequestMethod
+var requestMethods = []string{"", "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
+// envoy.data.accesslog.v2.ResponseFlags field numbers to envoy's short
+// response flag codes.
+var responseFlags = map[int]string{
+	1:  "LH",
+	2:  "UH",
+	3:  "UT",
+	4:  "LR",
+	5:  "UR",
+	6:  "UF",
+	7:  "UC",
+	8:  "UO",
+	9:  "NR",
+	10: "DI",
+	11: "FI",
+	12: "RL",
+	13: "UAEX",
+	14: "RLSE",
+	15: "DC",
+	16: "URX",
+	17: "SI",
+	18: "IH",
+	19: "DPE",
+}
+
+func decodeResponseFlags(msg []byte) (string, error) {
+	flags := []string{}
+	err := walk(msg, func(f field) error {
+		if code, ok := responseFlags[f.num]; ok && f.wire == wireVarint && f.varint != 0 {
+			flags = append(flags, code)
+		}
+		return nil
+	})
+	return strings.Join(flags, ","), err
+}
+
+// decodeCommon decodes envoy.data.accesslog.v2.AccessLogCommon.
+func decodeCommon(msg []byte, e *Entry) error {
+	var lastDownstreamTx, firstUpstreamTx, lastUpstreamRx time.Duration
+	err := walk(msg, func(f field) error {
+		var err error
+		switch f.num {
+		case 5:
+			e.StartTime, err = decodeTimestamp(f.bytes)
+		case 7:
+			firstUpstreamTx, err = decodeDuration(f.bytes)
+		case 10:
+			lastUpstreamRx, err = decodeDuration(f.bytes)
+		case 12:
+			lastDownstreamTx, err = decodeDuration(f.bytes)
+		case 13:
+			e.UpstreamHost, err = decodeAddress(f.bytes)
+		case 15:
+			e.UpstreamCluster = string(f.bytes)
+		case 16:
+			e.ResponseFlags, err = decodeResponseFlags(f.bytes)
+		case 19:
+			e.Route = string(f.bytes)
+		}
+		return err
+	})
+
+	e.Duration = lastDownstreamTx
+	if lastUpstreamRx > 0 {
+		e.UpstreamDuration = lastUpstreamRx - firstUpstreamTx
+	}
+	return err
+}
+
+// decodeHTTPEntry decodes envoy.data.accesslog.v2.HTTPAccessLogEntry, the
+// v3 message shares its field numbers.
+func decodeHTTPEntry(msg []byte) (Entry, error) {
+	e := Entry{}
+	err := walk(msg, func(f field) error {
+		switch f.num {
+		case 1:
+			return decodeCommon(f.bytes, &e)
+		case 3:
+			return walk(f.bytes, func(rf field) error {
+				switch rf.num {
+				case 1:
+					if int(rf.varint) < len(requestMethods) {
+						e.Method = requestMethods[rf.varint]
+					}
+				case 3:
+					e.Authority = string(rf.bytes)
+				case 5:
+					e.Path = string(rf.bytes)
+				case 9:
+					e.RequestID = string(rf.bytes)
+				case 12:
+					e.BytesReceived = rf.varint
+				}
+				return nil
+			})
+		case 4:
+			return walk(f.bytes, func(rf field) error {
+				var err error
+				switch rf.num {
+				case 1:
+					var code uint32
+					code, err = decodeUInt32Value(rf.bytes)
+					e.Status = int(code)
+				case 3:
+					e.BytesSent = rf.varint
+				}
+				return err
+			})
+		}
+		return nil
+	})
+	return e, err
+}
+
+// DecodeStreamMessage decodes an envoy.service.accesslog.v2
+// StreamAccessLogsMessage and returns its http entries, tcp entries are
+// ignored.  The identifier is only sent on the first message of a stream so
+// logName carries it across messages.
+func DecodeStreamMessage(msg []byte, logName *string) ([]Entry, error) {
+	entries := []Entry{}
+	err := walk(msg, func(f field) error {
+		switch f.num {
+		case 1:
+			return walk(f.bytes, func(idf field) error {
+				if idf.num == 2 {
+					*logName = string(idf.bytes)
+				}
+				return nil
+			})
+		case 2:
+			return walk(f.bytes, func(lf field) error {
+				if lf.num != 1 {
+					return nil
+				}
+				e, err := decodeHTTPEntry(lf.bytes)
+				if err != nil {
+					return err
+				}
+				e.LogName = *logName
+				entries = append(entries, e)
+				return nil
+			})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// number accepts json numbers and strings, envoy's json formatter writes
+// every value as a string, "-" when it's unset.
+type number float64
+
+func (n *number) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "-" || s == "null" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", b)
+	}
+	*n = number(f)
+	return nil
+}
+
+// jsonEntry accepts both the keys of envoy's json_format in config/envoy.yaml
+// and the access log of cmd/proxy.
+type jsonEntry struct {
+	StartTime           time.Time `json:"start_time"`
+	Route               string    `json:"route"`
+	RouteName           string    `json:"route_name"`
+	UpstreamCluster     string    `json:"upstream_cluster"`
+	UpstreamHost        string    `json:"upstream_host"`
+	Method              string    `json:"method"`
+	Authority           string    `json:"authority"`
+	Path                string    `json:"path"`
+	RequestID           string    `json:"request_id"`
+	ResponseCode        number    `json:"response_code"`
+	ResponseFlags       string    `json:"response_flags"`
+	BytesReceived       number    `json:"bytes_received"`
+	BytesSent           number    `json:"bytes_sent"`
+	Duration            number    `json:"duration"`
+	DurationMS          number    `json:"duration_ms"`
+	UpstreamServiceTime number    `json:"upstream_service_time"`
+	Listener            string    `json:"listener"`
+}
+
+// DecodeJSON decodes a json access log line, durations are in
+// milliseconds.
+func DecodeJSON(line []byte) (Entry, error) {
+	j := jsonEntry{}
+	if err := json.Unmarshal(line, &j); err != nil {
+		return Entry{}, err
+	}
+
+	e := Entry{
+		LogName:          j.Listener,
+		StartTime:        j.StartTime,
+		Route:            j.Route,
+		UpstreamCluster:  j.UpstreamCluster,
+		UpstreamHost:     j.UpstreamHost,
+		Method:           j.Method,
+		Authority:        j.Authority,
+		Path:             j.Path,
+		RequestID:        j.RequestID,
+		Status:           int(j.ResponseCode),
+		BytesReceived:    uint64(j.BytesReceived),
+		BytesSent:        uint64(j.BytesSent),
+		Duration:         time.Duration(float64(j.Duration+j.DurationMS) * float64(time.Millisecond)),
+		UpstreamDuration: time.Duration(float64(j.UpstreamServiceTime) * float64(time.Millisecond)),
+	}
+	if e.Route == "" {
+		e.Route = j.RouteName
+	}
+	if j.ResponseFlags != "-" {
+		e.ResponseFlags = j.ResponseFlags
+	}
+	return e, nil
+}
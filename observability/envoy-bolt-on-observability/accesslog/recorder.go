@@ -0,0 +1,144 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_log_requests_total",
+		Help: "Requests in the access logs by route, upstream cluster and status",
+	}, []string{"route", "upstream_cluster", "status", "response_flags"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "access_log_request_duration_seconds",
+		Help: "Distribution of request durations, until the last byte was sent downstream",
+	}, []string{"route", "upstream_cluster"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "access_log_upstream_duration_seconds",
+		Help: "Distribution of upstream request durations by upstream host",
+	}, []string{"upstream_cluster", "upstream_host"})
+
+	decodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_log_decode_errors_total",
+		Help: "Access log messages that couldn't be decoded, source=grpc|json",
+	}, []string{"source"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(upstreamDuration)
+	prometheus.MustRegister(decodeErrors)
+}
+
+// Recorder turns entries into metrics and keeps the most recent ones in a
+// ring buffer so slow and failed requests can be looked up.
+type Recorder struct {
+	mu     sync.Mutex
+	recent []Entry
+	next   int
+	full   bool
+}
+
+// NewRecorder returns a recorder that keeps the size most recent entries.
+func NewRecorder(size int) (*Recorder, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("recent size must be >= 1, received: %d", size)
+	}
+	return &Recorder{
+		recent: make([]Entry, size),
+	}, nil
+}
+
+func (r *Recorder) Record(e Entry) {
+	requestsTotal.WithLabelValues(e.Route, e.UpstreamCluster, strconv.Itoa(e.Status), e.ResponseFlags).Inc()
+	requestDuration.WithLabelValues(e.Route, e.UpstreamCluster).Observe(e.Duration.Seconds())
+	if e.UpstreamHost != "" && e.UpstreamDuration > 0 {
+		upstreamDuration.WithLabelValues(e.UpstreamCluster, e.UpstreamHost).Observe(e.UpstreamDuration.Seconds())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recent[r.next] = e
+	r.next = (r.next + 1) % len(r.recent)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns up to limit of the most recent entries matching keep,
+// newest first.
+func (r *Recorder) Recent(limit int, keep func(Entry) bool) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.recent)
+	}
+
+	entries := []Entry{}
+	for i := 1; i <= n && len(entries) < limit; i++ {
+		e := r.recent[(r.next-i+len(r.recent))%len(r.recent)]
+		if keep(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func writeEntries(w http.ResponseWriter, entries []Entry) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Entries []Entry `json:"entries"`
+	}{entries})
+}
+
+func queryLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		return 50
+	}
+	return limit
+}
+
+// SlowHandler serves the recent requests slower than ?threshold=, which
+// defaults to threshold, optionally filtered by ?route=.
+func (r *Recorder) SlowHandler(threshold time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t := threshold
+		if s := req.URL.Query().Get("threshold"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			t = d
+		}
+		route := req.URL.Query().Get("route")
+
+		writeEntries(w, r.Recent(queryLimit(req), func(e Entry) bool {
+			return e.Duration >= t && (route == "" || e.Route == route)
+		}))
+	})
+}
+
+// FailedHandler serves the recent failed requests, optionally filtered by
+// ?route=.
+func (r *Recorder) FailedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := req.URL.Query().Get("route")
+		writeEntries(w, r.Recent(queryLimit(req), func(e Entry) bool {
+			return e.Failed() && (route == "" || e.Route == route)
+		}))
+	})
+}
@@ -0,0 +1,73 @@
+package accesslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// ReadJSON records each json line of r until it's exhausted.
+func ReadJSON(r io.Reader, recorder *Recorder) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || line[0] != '{' {
+			// interleaved non access log output, ie envoy's own logs
+			continue
+		}
+		e, err := DecodeJSON(line)
+		if err != nil {
+			decodeErrors.WithLabelValues("json").Inc()
+			log.Printf("decoding json access log: %s", err)
+			continue
+		}
+		recorder.Record(e)
+	}
+	return scanner.Err()
+}
+
+// TailJSON follows the json access log at path from its current end, like
+// tail -f, until ctx is done.  "-" reads stdin instead and returns nil once
+// stdin is exhausted.
+func TailJSON(ctx context.Context, path string, recorder *Recorder, poll time.Duration) error {
+	if path == "-" {
+		return ReadJSON(os.Stdin, recorder)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	partial := []byte{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		partial = append(partial, line...)
+		if err == io.EOF {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(poll):
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := ReadJSON(bytes.NewReader(partial), recorder); err != nil {
+			return err
+		}
+		partial = partial[:0]
+	}
+}
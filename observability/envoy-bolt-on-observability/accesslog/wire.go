@@ -0,0 +1,131 @@
+package accesslog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// The envoy access log messages are decoded straight from the protobuf
+// wire format, only the fields the receiver uses are read and everything
+// else is skipped.  This keeps the receiver free of the generated envoy
+// protos and their dependencies.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type field struct {
+	num    int
+	wire   int
+	varint uint64
+	bytes  []byte
+}
+
+// walk calls fn for every field of msg in order.
+func walk(msg []byte, fn func(f field) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("malformed field key")
+		}
+		msg = msg[n:]
+
+		f := field{num: int(key >> 3), wire: int(key & 0x7)}
+		switch f.wire {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", f.num)
+			}
+			msg = msg[n:]
+		case wireFixed64:
+			if len(msg) < 8 {
+				return fmt.Errorf("truncated fixed64 in field %d", f.num)
+			}
+			f.varint = binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return fmt.Errorf("truncated bytes in field %d", f.num)
+			}
+			f.bytes = msg[n : n+int(l)]
+			msg = msg[n+int(l):]
+		case wireFixed32:
+			if len(msg) < 4 {
+				return fmt.Errorf("truncated fixed32 in field %d", f.num)
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(msg))
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", f.wire, f.num)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeDuration decodes a google.protobuf.Duration, or Timestamp, whose
+// seconds and nanos share field numbers.
+func decodeDuration(msg []byte) (time.Duration, error) {
+	var seconds, nanos int64
+	err := walk(msg, func(f field) error {
+		switch f.num {
+		case 1:
+			seconds = int64(f.varint)
+		case 2:
+			nanos = int64(int32(f.varint))
+		}
+		return nil
+	})
+	return time.Duration(seconds)*time.Second + time.Duration(nanos), err
+}
+
+func decodeTimestamp(msg []byte) (time.Time, error) {
+	d, err := decodeDuration(msg)
+	return time.Unix(0, int64(d)).UTC(), err
+}
+
+// decodeUInt32Value decodes a google.protobuf.UInt32Value wrapper.
+func decodeUInt32Value(msg []byte) (uint32, error) {
+	var v uint32
+	err := walk(msg, func(f field) error {
+		if f.num == 1 {
+			v = uint32(f.varint)
+		}
+		return nil
+	})
+	return v, err
+}
+
+// decodeAddress decodes an envoy.api.v2.core.Address holding a
+// SocketAddress into host:port.
+func decodeAddress(msg []byte) (string, error) {
+	var addr string
+	err := walk(msg, func(f field) error {
+		if f.num != 1 {
+			return nil
+		}
+		var host string
+		var port uint64
+		err := walk(f.bytes, func(sf field) error {
+			switch sf.num {
+			case 2:
+				host = string(sf.bytes)
+			case 3:
+				port = sf.varint
+			}
+			return nil
+		})
+		addr = fmt.Sprintf("%s:%d", host, port)
+		return err
+	})
+	return addr, err
+}
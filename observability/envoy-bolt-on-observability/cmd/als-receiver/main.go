@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dm03514/sre-tutorials/observability/envoy-bolt-on-observability/accesslog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	grpcAddr := flag.String("grpc-addr", ":9904",
+		"address envoy's grpc access log service cluster points to, disabled when -json-log is set")
+	httpAddr := flag.String("http-addr", ":9905", "address /metrics and the /requests queries listen on")
+	jsonLog := flag.String("json-log", "", "json access log file to follow, - reads stdin")
+	recent := flag.Int("recent", 10000, "number of recent requests kept for the /requests queries")
+	slowThreshold := flag.Duration("slow-threshold", 100*time.Millisecond, "default threshold of /requests/slow")
+	flag.Parse()
+
+	// envoy writes every request to both logs, a receiver reads one of them
+	// so requests aren't counted twice
+	if *jsonLog != "" {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "grpc-addr" && f.Value.String() != "" {
+				log.Fatal("-grpc-addr and -json-log are exclusive, every request is in both logs")
+			}
+		})
+		*grpcAddr = ""
+	}
+
+	recorder, err := accesslog.NewRecorder(*recent)
+	if err != nil {
+		log.Fatal(err)
+	}
+	errs := make(chan error)
+
+	if *grpcAddr != "" {
+		go func() {
+			fmt.Printf("starting_als: %q\n", *grpcAddr)
+			errs <- accesslog.NewALSServer(recorder).ListenAndServe(*grpcAddr)
+		}()
+	}
+
+	if *jsonLog != "" {
+		go func() {
+			fmt.Printf("following_json_log: %q\n", *jsonLog)
+			err := accesslog.TailJSON(context.Background(), *jsonLog, recorder, time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			// stdin ran out, ie a finished log was piped in, its requests
+			// can still be queried
+			log.Printf("json log %q reached EOF, still serving /metrics and /requests", *jsonLog)
+		}()
+	}
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/requests/slow", recorder.SlowHandler(*slowThreshold))
+		mux.Handle("/requests/failed", recorder.FailedHandler())
+		fmt.Printf("starting_http: %q\n", *httpAddr)
+		errs <- http.ListenAndServe(*httpAddr, mux)
+	}()
+
+	log.Fatal(<-errs)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dm03514/sre-tutorials/observability/envoy-bolt-on-observability/envoyconfig"
+)
+
+func main() {
+	servicePath := flag.String("service", "config/service.yaml", "service description the bootstrap is generated from")
+	out := flag.String("out", "config/envoy.yaml", "path the bootstrap is written to, - for stdout")
+	check := flag.Bool("check", false, "validate the bootstrap at -out instead of generating it")
+	flag.Parse()
+
+	if *check {
+		b, err := envoyconfig.LoadBootstrap(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := b.Validate(); err != nil {
+			log.Fatalf("%s: %s", *out, err)
+		}
+		fmt.Printf("valid: %q\n", *out)
+		return
+	}
+
+	s, err := envoyconfig.LoadService(*servicePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// checked before -out is truncated so a broken service doesn't replace
+	// a working bootstrap
+	b := envoyconfig.Generate(s)
+	if err := b.Validate(); err != nil {
+		log.Fatalf("generated bootstrap is invalid: %s", err)
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+
+	header := fmt.Sprintf("generated by cmd/envoy-config from %s, DO NOT EDIT", *servicePath)
+	if err := b.Write(w, header); err != nil {
+		log.Fatal(err)
+	}
+}
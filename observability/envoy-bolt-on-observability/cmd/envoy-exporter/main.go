@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dm03514/sre-tutorials/observability/envoy-bolt-on-observability/envoystats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	adminURL := flag.String("admin-url", "http://localhost:9901", "envoy admin interface")
+	addr := flag.String("addr", ":9902", "address the /metrics endpoint listens on")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout of each scrape of the admin interface")
+	flag.Parse()
+
+	prometheus.MustRegister(envoystats.NewCollector(
+		envoystats.NewAdmin(*adminURL, *timeout),
+		*timeout,
+	))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	fmt.Printf("starting_server: %q, envoy_admin: %q\n", *addr, *adminURL)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
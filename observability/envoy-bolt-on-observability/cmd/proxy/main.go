@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dm03514/sre-tutorials/observability/envoy-bolt-on-observability/proxy"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func main() {
+	configPath := flag.String("config", "config/proxy.yaml", "")
+	adminAddr := flag.String("admin-addr", ":9903", "address the /metrics endpoint listens on")
+	flag.Parse()
+
+	conf, err := proxy.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errs := make(chan error)
+	for _, l := range conf.Listeners {
+		var accessLog *proxy.AccessLog
+		if l.AccessLog != "" {
+			if accessLog, err = proxy.OpenAccessLog(l.AccessLog); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		s := &http.Server{
+			Addr:    l.Address,
+			Handler: proxy.NewHandler(l, accessLog),
+		}
+		fmt.Printf("starting_listener: %q, address: %q\n", l.Name, l.Address)
+		go func() {
+			errs <- s.ListenAndServe()
+		}()
+	}
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		fmt.Printf("starting_admin: %q\n", *adminAddr)
+		errs <- http.ListenAndServe(*adminAddr, mux)
+	}()
+
+	log.Fatal(<-errs)
+}
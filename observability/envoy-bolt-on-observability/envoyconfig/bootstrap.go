@@ -0,0 +1,214 @@
+package envoyconfig
+
+// The types below are the subset of envoy's v2 bootstrap that the generated
+// configs use.  Typed configs are a union of the extensions used, @type
+// says which one is set.
+
+const (
+	hcmType         = "type.googleapis.com/envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager"
+	grpcAccessLog   = "type.googleapis.com/envoy.config.accesslog.v2.HttpGrpcAccessLogConfig"
+	fileAccessLog   = "type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog"
+	statsdSinkType  = "type.googleapis.com/envoy.config.metrics.v2.StatsdSink"
+	zipkinType      = "type.googleapis.com/envoy.config.trace.v2.ZipkinConfig"
+	hcmFilter       = "envoy.http_connection_manager"
+	routerFilter    = "envoy.router"
+	grpcAccessLogID = "envoy.http_grpc_access_log"
+	fileAccessLogID = "envoy.file_access_log"
+	statsdSinkID    = "envoy.statsd"
+	zipkinID        = "envoy.zipkin"
+)
+
+type Bootstrap struct {
+	Node            Node            `yaml:"node"`
+	StaticResources StaticResources `yaml:"static_resources"`
+	StatsSinks      []StatsSink     `yaml:"stats_sinks,omitempty"`
+	Tracing         *Tracing        `yaml:"tracing,omitempty"`
+	Admin           Admin           `yaml:"admin"`
+}
+
+// Node identifies the envoy, the zipkin tracer requires its cluster.
+type Node struct {
+	ID      string `yaml:"id"`
+	Cluster string `yaml:"cluster"`
+}
+
+type StaticResources struct {
+	Listeners []Listener `yaml:"listeners"`
+	Clusters  []Cluster  `yaml:"clusters"`
+}
+
+type SocketAddress struct {
+	Address   string `yaml:"address"`
+	PortValue int    `yaml:"port_value"`
+}
+
+type Address struct {
+	SocketAddress SocketAddress `yaml:"socket_address"`
+}
+
+type Listener struct {
+	Name         string        `yaml:"name"`
+	Address      Address       `yaml:"address"`
+	FilterChains []FilterChain `yaml:"filter_chains"`
+}
+
+type FilterChain struct {
+	Filters []Filter `yaml:"filters"`
+}
+
+type Filter struct {
+	Name        string                 `yaml:"name"`
+	TypedConfig *HTTPConnectionManager `yaml:"typed_config"`
+}
+
+// HCMTracing enables tracing of the connection manager's requests, the
+// tracer is configured by the bootstrap's Tracing.
+type HCMTracing struct{}
+
+type HTTPConnectionManager struct {
+	Type             string             `yaml:"@type"`
+	UseRemoteAddress bool               `yaml:"use_remote_address"`
+	StatPrefix       string             `yaml:"stat_prefix"`
+	CodecType        string             `yaml:"codec_type"`
+	Tracing          *HCMTracing        `yaml:"tracing,omitempty"`
+	RouteConfig      RouteConfiguration `yaml:"route_config"`
+	AccessLog        []AccessLog        `yaml:"access_log,omitempty"`
+	HTTPFilters      []HTTPFilter       `yaml:"http_filters"`
+}
+
+type RouteConfiguration struct {
+	Name         string        `yaml:"name"`
+	VirtualHosts []VirtualHost `yaml:"virtual_hosts"`
+}
+
+type VirtualHost struct {
+	Name    string       `yaml:"name"`
+	Domains []string     `yaml:"domains"`
+	Routes  []RouteEntry `yaml:"routes"`
+}
+
+type RouteMatch struct {
+	Prefix string `yaml:"prefix"`
+}
+
+type RetryPolicy struct {
+	RetryOn       string `yaml:"retry_on"`
+	NumRetries    int    `yaml:"num_retries"`
+	PerTryTimeout string `yaml:"per_try_timeout,omitempty"`
+}
+
+type RouteAction struct {
+	Cluster     string       `yaml:"cluster"`
+	Timeout     string       `yaml:"timeout,omitempty"`
+	RetryPolicy *RetryPolicy `yaml:"retry_policy,omitempty"`
+}
+
+type RouteEntry struct {
+	Name  string      `yaml:"name"`
+	Match RouteMatch  `yaml:"match"`
+	Route RouteAction `yaml:"route"`
+}
+
+type EnvoyGrpc struct {
+	ClusterName string `yaml:"cluster_name"`
+}
+
+type GrpcService struct {
+	EnvoyGrpc EnvoyGrpc `yaml:"envoy_grpc"`
+}
+
+type CommonGrpcAccessLogConfig struct {
+	LogName     string      `yaml:"log_name"`
+	GrpcService GrpcService `yaml:"grpc_service"`
+}
+
+// AccessLogConfig is either a grpc or a file access log.
+type AccessLogConfig struct {
+	Type         string                     `yaml:"@type"`
+	CommonConfig *CommonGrpcAccessLogConfig `yaml:"common_config,omitempty"`
+	Path         string                     `yaml:"path,omitempty"`
+	JSONFormat   map[string]string          `yaml:"json_format,omitempty"`
+}
+
+type AccessLog struct {
+	Name        string          `yaml:"name"`
+	TypedConfig AccessLogConfig `yaml:"typed_config"`
+}
+
+type HTTPFilter struct {
+	Name string `yaml:"name"`
+}
+
+type HTTPHealthCheck struct {
+	Path string `yaml:"path"`
+}
+
+type ClusterHealthCheck struct {
+	Timeout            string           `yaml:"timeout"`
+	Interval           string           `yaml:"interval"`
+	UnhealthyThreshold int              `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int              `yaml:"healthy_threshold"`
+	HTTPHealthCheck    *HTTPHealthCheck `yaml:"http_health_check"`
+}
+
+type Endpoint struct {
+	Address Address `yaml:"address"`
+}
+
+type LbEndpoint struct {
+	Endpoint Endpoint `yaml:"endpoint"`
+}
+
+type LocalityLbEndpoints struct {
+	LbEndpoints []LbEndpoint `yaml:"lb_endpoints"`
+}
+
+type ClusterLoadAssignment struct {
+	ClusterName string                `yaml:"cluster_name"`
+	Endpoints   []LocalityLbEndpoints `yaml:"endpoints"`
+}
+
+// HTTP2ProtocolOptions is set on clusters that speak grpc.
+type HTTP2ProtocolOptions struct{}
+
+type Cluster struct {
+	Name                 string                `yaml:"name"`
+	ConnectTimeout       string                `yaml:"connect_timeout"`
+	Type                 string                `yaml:"type"`
+	LbPolicy             string                `yaml:"lb_policy,omitempty"`
+	HTTP2ProtocolOptions *HTTP2ProtocolOptions `yaml:"http2_protocol_options,omitempty"`
+	HealthChecks         []ClusterHealthCheck  `yaml:"health_checks,omitempty"`
+	LoadAssignment       ClusterLoadAssignment `yaml:"load_assignment"`
+}
+
+type StatsdSink struct {
+	Type    string  `yaml:"@type"`
+	Address Address `yaml:"address"`
+	Prefix  string  `yaml:"prefix,omitempty"`
+}
+
+type StatsSink struct {
+	Name        string     `yaml:"name"`
+	TypedConfig StatsdSink `yaml:"typed_config"`
+}
+
+type ZipkinConfig struct {
+	Type                     string `yaml:"@type"`
+	CollectorCluster         string `yaml:"collector_cluster"`
+	CollectorEndpoint        string `yaml:"collector_endpoint"`
+	CollectorEndpointVersion string `yaml:"collector_endpoint_version,omitempty"`
+}
+
+type TracingProvider struct {
+	Name        string       `yaml:"name"`
+	TypedConfig ZipkinConfig `yaml:"typed_config"`
+}
+
+type Tracing struct {
+	HTTP TracingProvider `yaml:"http"`
+}
+
+type Admin struct {
+	AccessLogPath string  `yaml:"access_log_path"`
+	Address       Address `yaml:"address"`
+}
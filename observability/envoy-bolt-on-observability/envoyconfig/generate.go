@@ -0,0 +1,239 @@
+package envoyconfig
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	accessLogCluster = "access_log_service"
+	zipkinCluster    = "zipkin"
+)
+
+// accessLogFormat are the keys of the json access log, they're understood
+// by accesslog.DecodeJSON.
+var accessLogFormat = map[string]string{
+	"start_time":            "%START_TIME%",
+	"route_name":            "%ROUTE_NAME%",
+	"upstream_cluster":      "%UPSTREAM_CLUSTER%",
+	"upstream_host":         "%UPSTREAM_HOST%",
+	"method":                "%REQ(:METHOD)%",
+	"authority":             "%REQ(:AUTHORITY)%",
+	"path":                  "%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%",
+	"request_id":            "%REQ(X-REQUEST-ID)%",
+	"response_code":         "%RESPONSE_CODE%",
+	"response_flags":        "%RESPONSE_FLAGS%",
+	"bytes_received":        "%BYTES_RECEIVED%",
+	"bytes_sent":            "%BYTES_SENT%",
+	"duration":              "%DURATION%",
+	"upstream_service_time": "%RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)%",
+}
+
+// envoyDuration converts a go duration, which the service was validated
+// with, into the seconds envoy's json/yaml durations require, 1m -> 60s.
+func envoyDuration(d string) string {
+	pd, _ := time.ParseDuration(d)
+	return strconv.FormatFloat(pd.Seconds(), 'f', -1, 64) + "s"
+}
+
+func address(addr string) Address {
+	host, port, _ := splitAddress(addr)
+	return Address{SocketAddress: SocketAddress{Address: host, PortValue: port}}
+}
+
+// cluster is a cluster of the single host at addr, hostnames are resolved
+// with STRICT_DNS.
+func cluster(name string, addr string, connectTimeout string) Cluster {
+	t := "STRICT_DNS"
+	if host, _, _ := splitAddress(addr); net.ParseIP(host) != nil {
+		t = "STATIC"
+	}
+	return Cluster{
+		Name:           name,
+		ConnectTimeout: envoyDuration(connectTimeout),
+		Type:           t,
+		LoadAssignment: ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints: []LocalityLbEndpoints{{
+				LbEndpoints: []LbEndpoint{{
+					Endpoint: Endpoint{Address: address(addr)},
+				}},
+			}},
+		},
+	}
+}
+
+func retryPolicy(r *Retry) *RetryPolicy {
+	if r == nil {
+		return nil
+	}
+	p := &RetryPolicy{
+		RetryOn:    r.On,
+		NumRetries: r.NumRetries,
+	}
+	if r.PerTryTimeout != "" {
+		p.PerTryTimeout = envoyDuration(r.PerTryTimeout)
+	}
+	return p
+}
+
+// Generate builds the bootstrap of an envoy proxying the service with
+// its stats sent to statsd, access logs streamed to cmd/als-receiver and
+// written as json, requests traced with zipkin and the upstream actively
+// health checked.
+func Generate(s *Service) *Bootstrap {
+	routes := []RouteEntry{}
+	for _, r := range s.Routes {
+		retry := r.Retry
+		if retry == nil {
+			retry = s.Retry
+		}
+		routes = append(routes, RouteEntry{
+			Name:  r.Name,
+			Match: RouteMatch{Prefix: r.Prefix},
+			Route: RouteAction{
+				Cluster:     s.Name,
+				Timeout:     envoyDuration(r.Timeout),
+				RetryPolicy: retryPolicy(retry),
+			},
+		})
+	}
+
+	hcm := &HTTPConnectionManager{
+		Type:             hcmType,
+		UseRemoteAddress: true,
+		StatPrefix:       "ingress_http",
+		CodecType:        "auto",
+		Tracing:          &HCMTracing{},
+		RouteConfig: RouteConfiguration{
+			Name: s.Name,
+			VirtualHosts: []VirtualHost{{
+				Name:    s.Name,
+				Domains: []string{"*"},
+				Routes:  routes,
+			}},
+		},
+		AccessLog: []AccessLog{
+			{
+				Name: grpcAccessLogID,
+				TypedConfig: AccessLogConfig{
+					Type: grpcAccessLog,
+					CommonConfig: &CommonGrpcAccessLogConfig{
+						LogName: s.Name,
+						GrpcService: GrpcService{
+							EnvoyGrpc: EnvoyGrpc{ClusterName: accessLogCluster},
+						},
+					},
+				},
+			},
+			{
+				Name: fileAccessLogID,
+				TypedConfig: AccessLogConfig{
+					Type:       fileAccessLog,
+					Path:       s.AccessLogPath,
+					JSONFormat: accessLogFormat,
+				},
+			},
+		},
+		HTTPFilters: []HTTPFilter{{Name: routerFilter}},
+	}
+
+	upstream := cluster(s.Name, s.Upstream, s.ConnectTimeout)
+	upstream.LbPolicy = "ROUND_ROBIN"
+	upstream.HealthChecks = []ClusterHealthCheck{{
+		Timeout:            envoyDuration(s.HealthCheck.Timeout),
+		Interval:           envoyDuration(s.HealthCheck.Interval),
+		UnhealthyThreshold: s.HealthCheck.UnhealthyThreshold,
+		HealthyThreshold:   s.HealthCheck.HealthyThreshold,
+		HTTPHealthCheck:    &HTTPHealthCheck{Path: s.HealthCheck.Path},
+	}}
+
+	als := cluster(accessLogCluster, s.AccessLogService, "1s")
+	als.HTTP2ProtocolOptions = &HTTP2ProtocolOptions{}
+
+	return &Bootstrap{
+		Node: Node{
+			ID:      s.Name + "-envoy",
+			Cluster: s.Name,
+		},
+		StaticResources: StaticResources{
+			Listeners: []Listener{{
+				Name:    s.Name + "_ingress",
+				Address: address(s.Listen),
+				FilterChains: []FilterChain{{
+					Filters: []Filter{{
+						Name:        hcmFilter,
+						TypedConfig: hcm,
+					}},
+				}},
+			}},
+			Clusters: []Cluster{
+				upstream,
+				als,
+				cluster(zipkinCluster, s.Zipkin, "1s"),
+			},
+		},
+		StatsSinks: []StatsSink{{
+			Name: statsdSinkID,
+			TypedConfig: StatsdSink{
+				Type:    statsdSinkType,
+				Address: address(s.Statsd),
+			},
+		}},
+		Tracing: &Tracing{
+			HTTP: TracingProvider{
+				Name: zipkinID,
+				TypedConfig: ZipkinConfig{
+					Type:                     zipkinType,
+					CollectorCluster:         zipkinCluster,
+					CollectorEndpoint:        "/api/v2/spans",
+					CollectorEndpointVersion: "HTTP_JSON",
+				},
+			},
+		},
+		Admin: Admin{
+			AccessLogPath: "/dev/null",
+			Address:       address(s.Admin),
+		},
+	}
+}
+
+// LoadBootstrap decodes an envoy bootstrap, fields outside of the subset
+// above are rejected.
+func LoadBootstrap(path string) (*Bootstrap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := &Bootstrap{}
+	if err := yaml.UnmarshalStrict(b, bs); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	return bs, nil
+}
+
+// Write validates the bootstrap and writes it as yaml preceded by header,
+// which is written as comments.
+func (b *Bootstrap) Write(w io.Writer, header string) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if header != "" {
+		if _, err := fmt.Fprintf(w, "# %s\n", header); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,226 @@
+package envoyconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Retry is envoy's route retry policy, On is a comma separated list of
+// x-envoy-retry-on conditions.
+type Retry struct {
+	On            string `yaml:"on"`
+	NumRetries    int    `yaml:"num_retries"`
+	PerTryTimeout string `yaml:"per_try_timeout"`
+}
+
+// Route sends requests whose path starts with Prefix to the service.
+// Retry overrides the service's retry policy.
+type Route struct {
+	Name    string `yaml:"name"`
+	Prefix  string `yaml:"prefix"`
+	Timeout string `yaml:"timeout"`
+	Retry   *Retry `yaml:"retry"`
+}
+
+// HealthCheck is the active http health check envoy runs against every
+// upstream host.
+type HealthCheck struct {
+	Path               string `yaml:"path"`
+	Interval           string `yaml:"interval"`
+	Timeout            string `yaml:"timeout"`
+	HealthyThreshold   int    `yaml:"healthy_threshold"`
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold"`
+}
+
+// Service describes a service envoy is bolted on to.  Only Name, Upstream
+// and Routes are required, the observability addresses default to the
+// services of this tutorial's docker-compose.yml.
+type Service struct {
+	Name           string      `yaml:"name"`
+	Listen         string      `yaml:"listen"`
+	Admin          string      `yaml:"admin"`
+	Upstream       string      `yaml:"upstream"`
+	ConnectTimeout string      `yaml:"connect_timeout"`
+	Routes         []Route     `yaml:"routes"`
+	Retry          *Retry      `yaml:"retry"`
+	HealthCheck    HealthCheck `yaml:"health_check"`
+
+	// AccessLogService is the address of cmd/als-receiver
+	AccessLogService string `yaml:"access_log_service"`
+	AccessLogPath    string `yaml:"access_log_path"`
+	// Statsd is the address of the statsd stats sink
+	Statsd string `yaml:"statsd"`
+	// Zipkin is the address of the zipkin compatible trace collector
+	Zipkin string `yaml:"zipkin"`
+}
+
+func LoadService(path string) (*Service, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{}
+	if err := yaml.UnmarshalStrict(b, s); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	s.setDefaults()
+	return s, s.Validate()
+}
+
+func (s *Service) setDefaults() {
+	defaults := []struct {
+		value *string
+		def   string
+	}{
+		{&s.Listen, "0.0.0.0:10000"},
+		{&s.Admin, "0.0.0.0:9901"},
+		{&s.ConnectTimeout, "1s"},
+		{&s.HealthCheck.Path, "/"},
+		{&s.HealthCheck.Interval, "5s"},
+		{&s.HealthCheck.Timeout, "1s"},
+		{&s.AccessLogService, "127.0.0.1:9904"},
+		{&s.AccessLogPath, "/var/log/envoy/access.log"},
+		{&s.Statsd, "127.0.0.1:9125"},
+		{&s.Zipkin, "127.0.0.1:9411"},
+	}
+	for _, d := range defaults {
+		if *d.value == "" {
+			*d.value = d.def
+		}
+	}
+	if s.HealthCheck.HealthyThreshold == 0 {
+		s.HealthCheck.HealthyThreshold = 2
+	}
+	if s.HealthCheck.UnhealthyThreshold == 0 {
+		s.HealthCheck.UnhealthyThreshold = 3
+	}
+	for i := range s.Routes {
+		if s.Routes[i].Timeout == "" {
+			s.Routes[i].Timeout = "15s"
+		}
+	}
+}
+
+func validateDuration(name string, d string) error {
+	pd, err := time.ParseDuration(d)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %s", name, err)
+	}
+	if pd <= 0 {
+		return fmt.Errorf("%s must be positive, received %q", name, d)
+	}
+	return nil
+}
+
+func validateAddress(name string, addr string) error {
+	if _, _, err := splitAddress(addr); err != nil {
+		return fmt.Errorf("invalid %s: %s", name, err)
+	}
+	return nil
+}
+
+func (r *Retry) validate() error {
+	if r.On == "" || r.NumRetries <= 0 {
+		return fmt.Errorf("retry requires on and a positive num_retries")
+	}
+	if r.PerTryTimeout != "" {
+		return validateDuration("per_try_timeout", r.PerTryTimeout)
+	}
+	return nil
+}
+
+func (s *Service) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("service requires a name")
+	}
+	if strings.ContainsAny(s.Name, " .:") {
+		return fmt.Errorf("service name %q can't contain spaces, dots or colons, it's used in stat names", s.Name)
+	}
+
+	addrs := []struct {
+		name string
+		addr string
+	}{
+		{"listen", s.Listen},
+		{"admin", s.Admin},
+		{"upstream", s.Upstream},
+		{"access_log_service", s.AccessLogService},
+		{"statsd", s.Statsd},
+		{"zipkin", s.Zipkin},
+	}
+	for _, a := range addrs {
+		if err := validateAddress(a.name, a.addr); err != nil {
+			return err
+		}
+	}
+
+	durations := []struct {
+		name string
+		d    string
+	}{
+		{"connect_timeout", s.ConnectTimeout},
+		{"health_check.interval", s.HealthCheck.Interval},
+		{"health_check.timeout", s.HealthCheck.Timeout},
+	}
+	for _, d := range durations {
+		if err := validateDuration(d.name, d.d); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(s.HealthCheck.Path, "/") {
+		return fmt.Errorf("health_check.path must start with /, received %q", s.HealthCheck.Path)
+	}
+
+	if s.Retry != nil {
+		if err := s.Retry.validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Routes) == 0 {
+		return fmt.Errorf("service %q requires at least one route", s.Name)
+	}
+	names := map[string]bool{}
+	for _, r := range s.Routes {
+		if r.Name == "" {
+			return fmt.Errorf("route requires a name")
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate route %q", r.Name)
+		}
+		names[r.Name] = true
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return fmt.Errorf("route %q: prefix must start with /, received %q", r.Name, r.Prefix)
+		}
+		if err := validateDuration("timeout", r.Timeout); err != nil {
+			return fmt.Errorf("route %q: %s", r.Name, err)
+		}
+		if r.Retry != nil {
+			if err := r.Retry.validate(); err != nil {
+				return fmt.Errorf("route %q: %s", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func splitAddress(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("address %q requires a host", addr)
+	}
+	p, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, p, nil
+}
@@ -0,0 +1,223 @@
+package envoyconfig
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// envoy's yaml durations are proto3 json durations, seconds with an s
+// suffix, go durations like 1m or 500ms are rejected when envoy starts.
+var protoDuration = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,9})?s$`)
+
+var clusterTypes = map[string]bool{
+	"STATIC":      true,
+	"STRICT_DNS":  true,
+	"LOGICAL_DNS": true,
+}
+
+func validateProtoDuration(name string, d string) error {
+	if !protoDuration.MatchString(d) {
+		return fmt.Errorf("%s must be seconds with an s suffix, received %q", name, d)
+	}
+	return nil
+}
+
+func (a Address) validate(name string) error {
+	if a.SocketAddress.Address == "" {
+		return fmt.Errorf("%s requires socket_address.address", name)
+	}
+	if a.SocketAddress.PortValue <= 0 || a.SocketAddress.PortValue > 65535 {
+		return fmt.Errorf("%s has an invalid port_value %d", name, a.SocketAddress.PortValue)
+	}
+	return nil
+}
+
+func (c Cluster) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("cluster requires a name")
+	}
+	if err := validateProtoDuration("connect_timeout", c.ConnectTimeout); err != nil {
+		return err
+	}
+	if !clusterTypes[c.Type] {
+		return fmt.Errorf("unsupported type %q", c.Type)
+	}
+	if c.LoadAssignment.ClusterName != c.Name {
+		return fmt.Errorf("load_assignment.cluster_name %q doesn't match the cluster", c.LoadAssignment.ClusterName)
+	}
+
+	endpoints := 0
+	for _, locality := range c.LoadAssignment.Endpoints {
+		for _, e := range locality.LbEndpoints {
+			if err := e.Endpoint.Address.validate("endpoint"); err != nil {
+				return err
+			}
+			endpoints++
+		}
+	}
+	if endpoints == 0 {
+		return fmt.Errorf("load_assignment requires at least one endpoint")
+	}
+
+	for _, hc := range c.HealthChecks {
+		if err := validateProtoDuration("health check timeout", hc.Timeout); err != nil {
+			return err
+		}
+		if err := validateProtoDuration("health check interval", hc.Interval); err != nil {
+			return err
+		}
+		if hc.UnhealthyThreshold <= 0 || hc.HealthyThreshold <= 0 {
+			return fmt.Errorf("health check requires unhealthy_threshold and healthy_threshold")
+		}
+		if hc.HTTPHealthCheck == nil || hc.HTTPHealthCheck.Path == "" {
+			return fmt.Errorf("health check requires http_health_check.path")
+		}
+	}
+	return nil
+}
+
+func (hcm *HTTPConnectionManager) validate(b *Bootstrap, clusters map[string]Cluster) error {
+	if hcm.Type != hcmType {
+		return fmt.Errorf("typed_config @type must be %q, received %q", hcmType, hcm.Type)
+	}
+	if hcm.StatPrefix == "" {
+		return fmt.Errorf("requires a stat_prefix")
+	}
+	if hcm.Tracing != nil && b.Tracing == nil {
+		return fmt.Errorf("tracing is enabled but the bootstrap has no tracing provider")
+	}
+
+	if len(hcm.RouteConfig.VirtualHosts) == 0 {
+		return fmt.Errorf("route_config requires at least one virtual host")
+	}
+	for _, vh := range hcm.RouteConfig.VirtualHosts {
+		if vh.Name == "" || len(vh.Domains) == 0 {
+			return fmt.Errorf("virtual host requires a name and domains")
+		}
+		if len(vh.Routes) == 0 {
+			return fmt.Errorf("virtual host %q requires at least one route", vh.Name)
+		}
+		for _, r := range vh.Routes {
+			if r.Match.Prefix == "" {
+				return fmt.Errorf("route %q requires match.prefix", r.Name)
+			}
+			if _, ok := clusters[r.Route.Cluster]; !ok {
+				return fmt.Errorf("route %q references undefined cluster %q", r.Name, r.Route.Cluster)
+			}
+			if r.Route.Timeout != "" {
+				if err := validateProtoDuration(fmt.Sprintf("route %q timeout", r.Name), r.Route.Timeout); err != nil {
+					return err
+				}
+			}
+			if p := r.Route.RetryPolicy; p != nil {
+				if p.RetryOn == "" {
+					return fmt.Errorf("route %q retry_policy requires retry_on", r.Name)
+				}
+				if p.PerTryTimeout != "" {
+					if err := validateProtoDuration(fmt.Sprintf("route %q per_try_timeout", r.Name), p.PerTryTimeout); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	for _, al := range hcm.AccessLog {
+		switch al.TypedConfig.Type {
+		case grpcAccessLog:
+			cc := al.TypedConfig.CommonConfig
+			if cc == nil || cc.LogName == "" {
+				return fmt.Errorf("%s requires common_config.log_name", al.Name)
+			}
+			c, ok := clusters[cc.GrpcService.EnvoyGrpc.ClusterName]
+			if !ok {
+				return fmt.Errorf("%s references undefined cluster %q", al.Name, cc.GrpcService.EnvoyGrpc.ClusterName)
+			}
+			if c.HTTP2ProtocolOptions == nil {
+				return fmt.Errorf("%s cluster %q requires http2_protocol_options for grpc", al.Name, c.Name)
+			}
+		case fileAccessLog:
+			if al.TypedConfig.Path == "" {
+				return fmt.Errorf("%s requires a path", al.Name)
+			}
+		default:
+			return fmt.Errorf("access log %q has unsupported @type %q", al.Name, al.TypedConfig.Type)
+		}
+	}
+
+	if len(hcm.HTTPFilters) == 0 || hcm.HTTPFilters[len(hcm.HTTPFilters)-1].Name != routerFilter {
+		return fmt.Errorf("http_filters must end with %s", routerFilter)
+	}
+	return nil
+}
+
+// Validate statically checks the fields envoy requires and the references
+// between listeners, clusters, access logs and the tracer, so a broken
+// config is caught before envoy is started with it.
+func (b *Bootstrap) Validate() error {
+	if err := b.Admin.Address.validate("admin.address"); err != nil {
+		return err
+	}
+
+	clusters := map[string]Cluster{}
+	for _, c := range b.StaticResources.Clusters {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("cluster %q: %s", c.Name, err)
+		}
+		if _, ok := clusters[c.Name]; ok {
+			return fmt.Errorf("duplicate cluster %q", c.Name)
+		}
+		clusters[c.Name] = c
+	}
+
+	if len(b.StaticResources.Listeners) == 0 {
+		return fmt.Errorf("static_resources requires at least one listener")
+	}
+	for _, l := range b.StaticResources.Listeners {
+		if l.Name == "" {
+			return fmt.Errorf("listener requires a name")
+		}
+		if err := l.Address.validate(fmt.Sprintf("listener %q", l.Name)); err != nil {
+			return err
+		}
+		if len(l.FilterChains) == 0 {
+			return fmt.Errorf("listener %q requires a filter chain", l.Name)
+		}
+		for _, fc := range l.FilterChains {
+			for _, f := range fc.Filters {
+				if f.Name != hcmFilter || f.TypedConfig == nil {
+					return fmt.Errorf("listener %q: only %s filters with a typed_config are supported", l.Name, hcmFilter)
+				}
+				if err := f.TypedConfig.validate(b, clusters); err != nil {
+					return fmt.Errorf("listener %q: %s", l.Name, err)
+				}
+			}
+		}
+	}
+
+	for _, s := range b.StatsSinks {
+		if s.Name != statsdSinkID || s.TypedConfig.Type != statsdSinkType {
+			return fmt.Errorf("stats sink %q: only %s is supported", s.Name, statsdSinkID)
+		}
+		if err := s.TypedConfig.Address.validate("statsd address"); err != nil {
+			return err
+		}
+	}
+
+	if b.Tracing != nil {
+		z := b.Tracing.HTTP.TypedConfig
+		if b.Tracing.HTTP.Name != zipkinID || z.Type != zipkinType {
+			return fmt.Errorf("tracing: only %s is supported", zipkinID)
+		}
+		if _, ok := clusters[z.CollectorCluster]; !ok {
+			return fmt.Errorf("tracing references undefined cluster %q", z.CollectorCluster)
+		}
+		if z.CollectorEndpoint == "" {
+			return fmt.Errorf("tracing requires a collector_endpoint")
+		}
+		if b.Node.Cluster == "" {
+			return fmt.Errorf("zipkin tracing requires node.cluster")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package envoystats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Value is a stat value, envoy encodes 64 bit integers as json strings in
+// some responses and as numbers in others.
+type Value float64
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*v = Value(f)
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*v = Value(f)
+	return nil
+}
+
+type Stat struct {
+	Name  string `json:"name"`
+	Value *Value `json:"value"`
+}
+
+type QuantileValues struct {
+	// Interval is nil until the histogram has samples in the last flush
+	// interval.
+	Interval   *float64 `json:"interval"`
+	Cumulative *float64 `json:"cumulative"`
+}
+
+type Histogram struct {
+	Name   string           `json:"name"`
+	Values []QuantileValues `json:"values"`
+}
+
+type Histograms struct {
+	SupportedQuantiles []float64   `json:"supported_quantiles"`
+	ComputedQuantiles  []Histogram `json:"computed_quantiles"`
+}
+
+// Stats is the response of /stats?format=json.  Counters and gauges are
+// listed first, histograms are a single trailing entry.
+type Stats struct {
+	Stats []struct {
+		Stat
+		Histograms *Histograms `json:"histograms"`
+	} `json:"stats"`
+}
+
+type SocketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+type HealthStatus struct {
+	EDSHealthStatus         string `json:"eds_health_status"`
+	FailedActiveHealthCheck bool   `json:"failed_active_health_check"`
+	FailedOutlierCheck      bool   `json:"failed_outlier_check"`
+}
+
+type HostStatus struct {
+	Address struct {
+		SocketAddress SocketAddress `json:"socket_address"`
+	} `json:"address"`
+	Stats        []Stat       `json:"stats"`
+	HealthStatus HealthStatus `json:"health_status"`
+}
+
+func (h HostStatus) Host() string {
+	return fmt.Sprintf("%s:%d", h.Address.SocketAddress.Address, h.Address.SocketAddress.PortValue)
+}
+
+// Healthy is false when the host failed active health checking, was
+// ejected by outlier detection or isn't healthy according to EDS.
+func (h HostStatus) Healthy() bool {
+	eds := h.HealthStatus.EDSHealthStatus
+	return !h.HealthStatus.FailedActiveHealthCheck &&
+		!h.HealthStatus.FailedOutlierCheck &&
+		(eds == "" || eds == "HEALTHY")
+}
+
+type ClusterStatus struct {
+	Name         string       `json:"name"`
+	HostStatuses []HostStatus `json:"host_statuses"`
+}
+
+// Clusters is the response of /clusters?format=json.
+type Clusters struct {
+	ClusterStatuses []ClusterStatus `json:"cluster_statuses"`
+}
+
+// Admin is a client of envoy's admin interface, ie http://localhost:9901.
+type Admin struct {
+	url    string
+	client *http.Client
+}
+
+func NewAdmin(url string, timeout time.Duration) *Admin {
+	return &Admin{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (a *Admin) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, a.url+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("envoy admin %s responded with status %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (a *Admin) Stats(ctx context.Context) (*Stats, error) {
+	s := &Stats{}
+	return s, a.get(ctx, "/stats?format=json", s)
+}
+
+func (a *Admin) Clusters(ctx context.Context) (*Clusters, error) {
+	c := &Clusters{}
+	return c, a.get(ctx, "/clusters?format=json", c)
+}
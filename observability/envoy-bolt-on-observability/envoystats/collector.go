@@ -0,0 +1,265 @@
+package envoystats
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "envoy"
+
+// rule maps the envoy stats matching pattern to a prometheus metric, the
+// pattern's submatches are the metric's label values.  Envoy stat names
+// embed cluster and listener names, which may themselves contain dots, so
+// the stat is anchored on its suffix.
+type rule struct {
+	pattern   *regexp.Regexp
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// clusterSubScopes matches the cluster names captured from envoy's
+// per-origin and per-zone copies of the cluster stats, ie
+// cluster.<name>.external.upstream_rq_2xx, which would otherwise be
+// exported as extra clusters and double count when summed.
+var clusterSubScopes = regexp.MustCompile(`\.(external|internal|canary)$|\.zone\.`)
+
+// match returns the label values of stat, false when the rule doesn't
+// apply to it.
+func (r rule) match(stat string) ([]string, bool) {
+	m := r.pattern.FindStringSubmatch(stat)
+	if m == nil {
+		return nil, false
+	}
+	if strings.HasPrefix(stat, "cluster.") && clusterSubScopes.MatchString(m[1]) {
+		return nil, false
+	}
+	return m[1:], true
+}
+
+func scoped(scope string, stat string, labels []string, name string, help string,
+	valueType prometheus.ValueType) rule {
+
+	return rule{
+		pattern: regexp.MustCompile(fmt.Sprintf(`^%s\.(.+)\.%s$`, scope, stat)),
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name),
+			help, labels, nil),
+		valueType: valueType,
+	}
+}
+
+func cluster(stat string, name string, help string, valueType prometheus.ValueType) rule {
+	return scoped("cluster", regexp.QuoteMeta(stat), []string{"cluster"}, name, help, valueType)
+}
+
+func listener(stat string, name string, help string, valueType prometheus.ValueType) rule {
+	return scoped("listener", regexp.QuoteMeta(stat), []string{"listener"}, name, help, valueType)
+}
+
+var (
+	counter = prometheus.CounterValue
+	gauge   = prometheus.GaugeValue
+
+	statRules = []rule{
+		scoped("cluster", `upstream_rq_([1-5])xx`, []string{"cluster", "code_class"},
+			"cluster_upstream_rq_xx_total", "Upstream responses by status code class.", counter),
+		cluster("upstream_rq_total", "cluster_upstream_rq_total", "Upstream requests.", counter),
+		cluster("upstream_rq_completed", "cluster_upstream_rq_completed_total",
+			"Upstream requests that received a response.", counter),
+		cluster("upstream_rq_timeout", "cluster_upstream_rq_timeout_total",
+			"Upstream requests that timed out waiting for a response.", counter),
+		cluster("upstream_rq_retry", "cluster_upstream_rq_retry_total", "Upstream request retries.", counter),
+		cluster("upstream_rq_retry_success", "cluster_upstream_rq_retry_success_total",
+			"Upstream request retries that succeeded.", counter),
+		cluster("upstream_rq_retry_overflow", "cluster_upstream_rq_retry_overflow_total",
+			"Upstream requests not retried because of circuit breaking.", counter),
+		cluster("upstream_rq_pending_overflow", "cluster_upstream_rq_pending_overflow_total",
+			"Upstream requests that overflowed the connection pool or circuit breaker.", counter),
+		cluster("upstream_rq_active", "cluster_upstream_rq_active", "Active upstream requests.", gauge),
+		cluster("upstream_rq_pending_active", "cluster_upstream_rq_pending_active",
+			"Upstream requests waiting for a connection.", gauge),
+		cluster("upstream_cx_total", "cluster_upstream_cx_total", "Upstream connections.", counter),
+		cluster("upstream_cx_active", "cluster_upstream_cx_active", "Active upstream connections.", gauge),
+		cluster("upstream_cx_connect_fail", "cluster_upstream_cx_connect_fail_total",
+			"Upstream connection failures.", counter),
+		cluster("outlier_detection.ejections_enforced_total", "cluster_outlier_detection_ejections_enforced_total",
+			"Hosts ejected by outlier detection.", counter),
+		cluster("outlier_detection.ejections_active", "cluster_outlier_detection_ejections_active",
+			"Hosts currently ejected by outlier detection.", gauge),
+		cluster("membership_healthy", "cluster_membership_healthy", "Healthy hosts in the cluster.", gauge),
+		cluster("membership_total", "cluster_membership_total", "Hosts in the cluster.", gauge),
+
+		scoped("listener", `http\.([^.]+)\.downstream_rq_([1-5])xx`, []string{"listener", "stat_prefix", "code_class"},
+			"listener_http_downstream_rq_xx_total", "Downstream responses by status code class.", counter),
+		listener("downstream_cx_total", "listener_downstream_cx_total", "Downstream connections.", counter),
+		listener("downstream_cx_destroy", "listener_downstream_cx_destroy_total",
+			"Downstream connections closed.", counter),
+		listener("downstream_cx_active", "listener_downstream_cx_active", "Active downstream connections.", gauge),
+
+		scoped("http", `downstream_rq_total`, []string{"stat_prefix"},
+			"http_downstream_rq_total", "Downstream requests of the http connection manager.", counter),
+		scoped("http", `downstream_rq_active`, []string{"stat_prefix"},
+			"http_downstream_rq_active", "Active downstream requests of the http connection manager.", gauge),
+	}
+
+	// histograms are exported as a gauge per quantile, envoy only exposes
+	// computed quantiles over json.  The quantiles are of envoy's last
+	// stats flush interval, the cumulative ones since envoy started barely
+	// move once it's been up a while.  Quantiles without samples in the
+	// interval aren't exported.  All of them are in milliseconds.
+	histogramRules = []rule{
+		scoped("cluster", `upstream_rq_time`, []string{"cluster", "quantile"},
+			"cluster_upstream_rq_time_seconds", "Upstream request latency quantiles.", gauge),
+		scoped("cluster", `upstream_cx_connect_ms`, []string{"cluster", "quantile"},
+			"cluster_upstream_cx_connect_seconds", "Upstream connection establishment latency quantiles.", gauge),
+		scoped("http", `downstream_rq_time`, []string{"stat_prefix", "quantile"},
+			"http_downstream_rq_time_seconds", "Downstream request latency quantiles.", gauge),
+		scoped("listener", `downstream_cx_length_ms`, []string{"listener", "quantile"},
+			"listener_downstream_cx_length_seconds", "Downstream connection length quantiles.", gauge),
+	}
+
+	hostLabels  = []string{"cluster", "host"}
+	hostHealthy = prometheus.NewDesc("envoy_cluster_host_healthy",
+		"1 if the host passes health checking and outlier detection.", hostLabels, nil)
+	hostOutlierEjected = prometheus.NewDesc("envoy_cluster_host_outlier_ejected",
+		"1 if the host is ejected by outlier detection.", hostLabels, nil)
+	hostStats = map[string]struct {
+		desc      *prometheus.Desc
+		valueType prometheus.ValueType
+	}{
+		"rq_total":   {prometheus.NewDesc("envoy_cluster_host_rq_total", "Requests to the host.", hostLabels, nil), counter},
+		"rq_error":   {prometheus.NewDesc("envoy_cluster_host_rq_error_total", "Failed requests to the host.", hostLabels, nil), counter},
+		"rq_timeout": {prometheus.NewDesc("envoy_cluster_host_rq_timeout_total", "Timed out requests to the host.", hostLabels, nil), counter},
+		"cx_active":  {prometheus.NewDesc("envoy_cluster_host_cx_active", "Active connections to the host.", hostLabels, nil), gauge},
+	}
+
+	up             = prometheus.NewDesc("envoy_up", "1 if the last scrape of the admin interface succeeded.", nil, nil)
+	scrapeDuration = prometheus.NewDesc("envoy_scrape_duration_seconds",
+		"Duration of the last scrape of the admin interface.", nil, nil)
+)
+
+// Collector translates envoy's admin stats into prometheus metrics each
+// time it's collected.
+type Collector struct {
+	admin   *Admin
+	timeout time.Duration
+}
+
+func NewCollector(admin *Admin, timeout time.Duration) *Collector {
+	return &Collector{
+		admin:   admin,
+		timeout: timeout,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, r := range statRules {
+		ch <- r.desc
+	}
+	for _, r := range histogramRules {
+		ch <- r.desc
+	}
+	for _, s := range hostStats {
+		ch <- s.desc
+	}
+	ch <- hostHealthy
+	ch <- hostOutlierEjected
+	ch <- up
+	ch <- scrapeDuration
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	err := c.collect(ch)
+	ch <- prometheus.MustNewConstMetric(scrapeDuration, gauge, time.Since(start).Seconds())
+	if err != nil {
+		log.Printf("scraping envoy admin: %s", err)
+	}
+	ch <- prometheus.MustNewConstMetric(up, gauge, boolValue(err == nil))
+}
+
+func (c *Collector) collect(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	stats, err := c.admin.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	clusters, err := c.admin.Clusters(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range stats.Stats {
+		if s.Histograms != nil {
+			collectHistograms(ch, s.Histograms)
+			continue
+		}
+		if s.Value == nil {
+			continue
+		}
+		for _, r := range statRules {
+			labels, ok := r.match(s.Name)
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(r.desc, r.valueType, float64(*s.Value), labels...)
+			break
+		}
+	}
+
+	for _, cs := range clusters.ClusterStatuses {
+		for _, h := range cs.HostStatuses {
+			labels := []string{cs.Name, h.Host()}
+			ch <- prometheus.MustNewConstMetric(hostHealthy, gauge, boolValue(h.Healthy()), labels...)
+			ch <- prometheus.MustNewConstMetric(hostOutlierEjected, gauge,
+				boolValue(h.HealthStatus.FailedOutlierCheck), labels...)
+			for _, hs := range h.Stats {
+				s, ok := hostStats[hs.Name]
+				if !ok || hs.Value == nil {
+					continue
+				}
+				ch <- prometheus.MustNewConstMetric(s.desc, s.valueType, float64(*hs.Value), labels...)
+			}
+		}
+	}
+	return nil
+}
+
+func collectHistograms(ch chan<- prometheus.Metric, hs *Histograms) {
+	quantileLabels := []string{}
+	for _, q := range hs.SupportedQuantiles {
+		quantileLabels = append(quantileLabels, strconv.FormatFloat(q/100, 'g', 6, 64))
+	}
+
+	for _, h := range hs.ComputedQuantiles {
+		for _, r := range histogramRules {
+			labels, ok := r.match(h.Name)
+			if !ok {
+				continue
+			}
+			for i, v := range h.Values {
+				if v.Interval == nil || i >= len(quantileLabels) {
+					continue
+				}
+				ch <- prometheus.MustNewConstMetric(r.desc, gauge, *v.Interval/1000,
+					append(labels, quantileLabels[i])...)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry is written as a line of json per request, the fields
+// follow envoy's default access log format.
+type AccessLogEntry struct {
+	StartTime     time.Time `json:"start_time"`
+	Listener      string    `json:"listener"`
+	Route         string    `json:"route"`
+	RequestID     string    `json:"request_id"`
+	TraceID       string    `json:"trace_id"`
+	SpanID        string    `json:"span_id"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	Protocol      string    `json:"protocol"`
+	Status        int       `json:"response_code"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesSent     int64     `json:"bytes_sent"`
+	DurationMS    float64   `json:"duration_ms"`
+	Upstream      string    `json:"upstream_host"`
+	UpstreamError string    `json:"upstream_error,omitempty"`
+	RemoteAddr    string    `json:"downstream_remote_address"`
+	UserAgent     string    `json:"user_agent"`
+}
+
+type AccessLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// OpenAccessLog opens path for appending, "stdout" logs to stdout.
+func OpenAccessLog(path string) (*AccessLog, error) {
+	var w io.Writer = os.Stdout
+	if path != "stdout" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &AccessLog{
+		enc: json.NewEncoder(w),
+	}, nil
+}
+
+func (a *AccessLog) Log(e AccessLogEntry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enc.Encode(e)
+}
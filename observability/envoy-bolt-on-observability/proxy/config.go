@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Route proxies requests whose path starts with Prefix to Upstream, the
+// longest matching prefix of a listener wins.
+type Route struct {
+	Name        string `yaml:"name"`
+	Prefix      string `yaml:"prefix"`
+	Upstream    string `yaml:"upstream"`
+	Timeout     string `yaml:"timeout"`
+	StripPrefix bool   `yaml:"strip_prefix"`
+
+	upstream *url.URL
+	timeout  time.Duration
+}
+
+// Listener accepts downstream connections on Address.  AccessLog is a
+// path, "stdout" or empty to disable access logging.
+type Listener struct {
+	Name      string  `yaml:"name"`
+	Address   string  `yaml:"address"`
+	AccessLog string  `yaml:"access_log"`
+	Routes    []Route `yaml:"routes"`
+}
+
+type Config struct {
+	Listeners []Listener `yaml:"listeners"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	return c, c.Validate()
+}
+
+func (c *Config) Validate() error {
+	if len(c.Listeners) == 0 {
+		return fmt.Errorf("config requires at least one listener")
+	}
+
+	names := map[string]bool{}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if l.Name == "" || l.Address == "" {
+			return fmt.Errorf("listener %d requires a name and an address", i)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicate listener %q", l.Name)
+		}
+		names[l.Name] = true
+
+		if len(l.Routes) == 0 {
+			return fmt.Errorf("listener %q requires at least one route", l.Name)
+		}
+		for j := range l.Routes {
+			if err := l.Routes[j].validate(); err != nil {
+				return fmt.Errorf("listener %q: %s", l.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Route) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("route requires a name")
+	}
+	if !strings.HasPrefix(r.Prefix, "/") {
+		return fmt.Errorf("route %q: prefix must start with /, received %q", r.Name, r.Prefix)
+	}
+
+	u, err := url.Parse(r.Upstream)
+	if err != nil {
+		return fmt.Errorf("route %q: invalid upstream: %s", r.Name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("route %q: upstream must be an absolute http(s) url, received %q", r.Name, r.Upstream)
+	}
+	r.upstream = u
+
+	if r.Timeout == "" {
+		r.Timeout = "15s"
+	}
+	if r.timeout, err = time.ParseDuration(r.Timeout); err != nil {
+		return fmt.Errorf("route %q: invalid timeout: %s", r.Name, err)
+	}
+	if r.timeout <= 0 {
+		return fmt.Errorf("route %q: timeout must be positive, received %q", r.Name, r.Timeout)
+	}
+	return nil
+}
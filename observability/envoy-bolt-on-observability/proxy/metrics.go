@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	routeLabels = []string{"listener", "route"}
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_http_requests_total",
+		Help: "Requests proxied, code_class=2xx|3xx|4xx|5xx",
+	}, []string{"listener", "route", "method", "code_class"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "proxy_http_request_duration_seconds",
+		Help: "Distribution of request durations as seen by the proxy",
+	}, routeLabels)
+
+	requestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "proxy_http_requests_in_flight",
+		Help: "Requests currently being proxied",
+	}, routeLabels)
+
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_upstream_errors_total",
+		Help: "Requests that didn't receive an upstream response, reason=timeout|canceled|connect",
+	}, []string{"listener", "route", "reason"})
+
+	noRoute = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_no_route_total",
+		Help: "Requests that didn't match any route",
+	}, []string{"listener"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal)
+	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(requestsInFlight)
+	prometheus.MustRegister(upstreamErrors)
+	prometheus.MustRegister(noRoute)
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strings"
+	"time"
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+type upstreamErrorKey struct{}
+
+// statusClientClosedRequest is recorded when the downstream gave up before
+// the upstream responded, following nginx.  It keeps disconnects out of the
+// 5xx counts, the upstream didn't fail.
+const statusClientClosedRequest = 499
+
+// route is a configured Route with its reverse proxy.
+type route struct {
+	Route
+	proxy *httputil.ReverseProxy
+}
+
+// Handler routes the requests of a single listener to its upstreams,
+// recording RED metrics and an access log entry for each.
+type Handler struct {
+	listener  string
+	routes    []*route
+	accessLog *AccessLog
+}
+
+func errorReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	return "connect"
+}
+
+func newRoute(listener string, r Route) *route {
+	upstream := r.upstream
+	p := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = upstream.Scheme
+			req.URL.Host = upstream.Host
+			path := req.URL.Path
+			if r.StripPrefix {
+				path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, r.Prefix), "/")
+			}
+			req.URL.Path = strings.TrimSuffix(upstream.Path, "/") + path
+			req.Host = upstream.Host
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			reason := errorReason(err)
+			if errPtr, ok := req.Context().Value(upstreamErrorKey{}).(*string); ok {
+				*errPtr = err.Error()
+			}
+			upstreamErrors.WithLabelValues(listener, r.Name, reason).Inc()
+
+			switch reason {
+			case "canceled":
+				// nobody is left to read a response
+				w.WriteHeader(statusClientClosedRequest)
+			case "timeout":
+				http.Error(w, fmt.Sprintf("upstream %s: %s", reason, err), http.StatusGatewayTimeout)
+			default:
+				http.Error(w, fmt.Sprintf("upstream %s: %s", reason, err), http.StatusBadGateway)
+			}
+		},
+	}
+	return &route{
+		Route: r,
+		proxy: p,
+	}
+}
+
+func NewHandler(l Listener, accessLog *AccessLog) *Handler {
+	h := &Handler{
+		listener:  l.Name,
+		accessLog: accessLog,
+	}
+	for _, r := range l.Routes {
+		h.routes = append(h.routes, newRoute(l.Name, r))
+	}
+	// the longest prefix matches first
+	sort.SliceStable(h.routes, func(i, j int) bool {
+		return len(h.routes[i].Prefix) > len(h.routes[j].Prefix)
+	})
+	return h
+}
+
+func (h *Handler) match(path string) *route {
+	for _, r := range h.routes {
+		if strings.HasPrefix(path, r.Prefix) {
+			return r
+		}
+	}
+	return nil
+}
+
+func codeClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	path := r.URL.RequestURI()
+	requestID, span := propagate(r)
+	w.Header().Set(requestIDHeader, requestID)
+
+	rt := h.match(r.URL.Path)
+	if rt == nil {
+		noRoute.WithLabelValues(h.listener).Inc()
+		http.Error(w, "no route matched", http.StatusNotFound)
+		return
+	}
+
+	inFlight := requestsInFlight.WithLabelValues(h.listener, rt.Name)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	upstreamErr := ""
+	ctx, cancel := context.WithTimeout(r.Context(), rt.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, upstreamErrorKey{}, &upstreamErr)
+
+	body := &countingReader{ReadCloser: http.NoBody}
+	if r.Body != nil {
+		body.ReadCloser = r.Body
+	}
+	r.Body = body
+
+	sw := &statusWriter{ResponseWriter: w}
+	rt.proxy.ServeHTTP(sw, r.WithContext(ctx))
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	duration := time.Since(start)
+	requestsTotal.WithLabelValues(h.listener, rt.Name, r.Method, codeClass(sw.status)).Inc()
+	requestDuration.WithLabelValues(h.listener, rt.Name).Observe(duration.Seconds())
+
+	if h.accessLog == nil {
+		return
+	}
+	err := h.accessLog.Log(AccessLogEntry{
+		StartTime:     start.UTC(),
+		Listener:      h.listener,
+		Route:         rt.Name,
+		RequestID:     requestID,
+		TraceID:       span.TraceID,
+		SpanID:        span.SpanID,
+		Method:        r.Method,
+		Path:          path,
+		Protocol:      r.Proto,
+		Status:        sw.status,
+		BytesReceived: body.bytes,
+		BytesSent:     sw.bytes,
+		DurationMS:    float64(duration) / float64(time.Millisecond),
+		Upstream:      rt.upstream.Host,
+		UpstreamError: upstreamErr,
+		RemoteAddr:    r.RemoteAddr,
+		UserAgent:     r.UserAgent(),
+	})
+	if err != nil {
+		log.Printf("writing access log: %s", err)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	requestIDHeader   = "X-Request-Id"
+	traceparentHeader = "Traceparent"
+	b3TraceIDHeader   = "X-B3-Traceid"
+	b3SpanIDHeader    = "X-B3-Spanid"
+	b3ParentHeader    = "X-B3-Parentspanid"
+	b3SampledHeader   = "X-B3-Sampled"
+)
+
+func randomHex(bytes int) string {
+	b := make([]byte, bytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newRequestID() string {
+	b := randomHex(16)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", b[0:8], b[8:12], b[12:16], b[16:20], b[20:32])
+}
+
+// Span identifies the proxy's hop of a trace.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Sampled  bool
+}
+
+// parseTraceparent parses a w3c trace context traceparent header,
+// 00-<trace id>-<parent id>-<flags>.
+func parseTraceparent(h string) (traceID string, parentID string, sampled bool, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	return parts[1], parts[2], parts[3] == "01", true
+}
+
+// propagate continues the trace of the downstream request, w3c trace
+// context first and then b3, or starts a new one, and sets both sets of
+// headers on the upstream request so either kind of upstream can continue
+// it.  A request ID is added when the downstream didn't send one.
+func propagate(r *http.Request) (string, Span) {
+	requestID := r.Header.Get(requestIDHeader)
+	if requestID == "" {
+		requestID = newRequestID()
+		r.Header.Set(requestIDHeader, requestID)
+	}
+
+	span := Span{
+		SpanID:  randomHex(8),
+		Sampled: true,
+	}
+	if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+		span.TraceID = traceID
+		span.ParentID = parentID
+		span.Sampled = sampled
+	} else if traceID := r.Header.Get(b3TraceIDHeader); traceID != "" {
+		span.TraceID = traceID
+		span.ParentID = r.Header.Get(b3SpanIDHeader)
+		span.Sampled = r.Header.Get(b3SampledHeader) != "0"
+	} else {
+		span.TraceID = randomHex(16)
+	}
+
+	flags := "00"
+	sampled := "0"
+	if span.Sampled {
+		flags = "01"
+		sampled = "1"
+	}
+	// b3 trace ids may be 64 bits, traceparent requires 128
+	w3cTraceID := span.TraceID
+	if len(w3cTraceID) == 16 {
+		w3cTraceID = strings.Repeat("0", 16) + w3cTraceID
+	}
+	r.Header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-%s", w3cTraceID, span.SpanID, flags))
+	r.Header.Set(b3TraceIDHeader, span.TraceID)
+	r.Header.Set(b3SpanIDHeader, span.SpanID)
+	r.Header.Set(b3SampledHeader, sampled)
+	if span.ParentID != "" {
+		r.Header.Set(b3ParentHeader, span.ParentID)
+	} else {
+		r.Header.Del(b3ParentHeader)
+	}
+	return requestID, span
+}
@@ -0,0 +1,254 @@
+package analysis
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dm03514/analysis-methodology-simple-http/dashboard"
+	"github.com/dm03514/analysis-methodology-simple-http/promapi"
+)
+
+const (
+	LevelOK       = "ok"
+	LevelWarn     = "warn"
+	LevelCritical = "critical"
+	LevelNoData   = "no data"
+)
+
+// growthThreshold is how much a trend check may grow over the range,
+// relative to its starting value, before it's flagged.
+const growthThreshold = 0.2
+
+type Stats struct {
+	Samples int
+	Min     float64
+	Mean    float64
+	P95     float64
+	Max     float64
+	// Growth is the increase of the least squares fit over the range,
+	// relative to the fit's starting value.
+	Growth float64
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// growth fits a line through the samples and returns its rise over the
+// range relative to where it starts.
+func growth(samples []promapi.Sample) float64 {
+	if len(samples) < 3 {
+		return 0
+	}
+	t0 := samples[0].Time
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.Time.Sub(t0).Seconds()
+		sx += x
+		sy += s.Value
+		sxx += x * x
+		sxy += x * s.Value
+	}
+	n := float64(len(samples))
+	denom := n*sxx - sx*sx
+	if denom == 0 {
+		return 0
+	}
+	slope := (n*sxy - sx*sy) / denom
+	intercept := (sy - slope*sx) / n
+	span := samples[len(samples)-1].Time.Sub(t0).Seconds()
+
+	if intercept <= 0 {
+		return 0
+	}
+	return slope * span / intercept
+}
+
+func NewStats(samples []promapi.Sample) Stats {
+	values := []float64{}
+	finite := []promapi.Sample{}
+	for _, s := range samples {
+		// NaN shows up for ratios of zero rates, ie no traffic
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		values = append(values, s.Value)
+		finite = append(finite, s)
+	}
+	if len(values) == 0 {
+		return Stats{}
+	}
+	sort.Float64s(values)
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return Stats{
+		Samples: len(values),
+		Min:     values[0],
+		Mean:    sum / float64(len(values)),
+		P95:     percentile(values, 0.95),
+		Max:     values[len(values)-1],
+		Growth:  growth(finite),
+	}
+}
+
+// Finding is the result of a check for a single series.
+type Finding struct {
+	Check  Check
+	Series string
+	Stats  Stats
+	Level  string
+	Notes  []string
+}
+
+func severity(level string) int {
+	switch level {
+	case LevelCritical:
+		return 2
+	case LevelWarn:
+		return 1
+	}
+	return 0
+}
+
+func evaluate(c Check, stats Stats) (string, []string) {
+	if stats.Samples == 0 {
+		return LevelNoData, nil
+	}
+
+	level := LevelOK
+	notes := []string{}
+	switch {
+	case c.Critical != 0 && stats.P95 >= c.Critical:
+		level = LevelCritical
+		notes = append(notes, fmt.Sprintf("p95 %s is over the critical threshold %s",
+			formatValue(stats.P95, c.Unit), formatValue(c.Critical, c.Unit)))
+	case c.Warn != 0 && stats.P95 >= c.Warn:
+		level = LevelWarn
+		notes = append(notes, fmt.Sprintf("p95 %s is over the warning threshold %s",
+			formatValue(stats.P95, c.Unit), formatValue(c.Warn, c.Unit)))
+	}
+
+	if c.Trend && stats.Growth > growthThreshold {
+		if level == LevelOK {
+			level = LevelWarn
+		}
+		notes = append(notes, fmt.Sprintf("grew %.0f%% over the range without leveling off",
+			stats.Growth*100))
+	}
+	return level, notes
+}
+
+func seriesName(labels map[string]string) string {
+	keys := []string{}
+	for k := range labels {
+		if k == "__name__" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+type Report struct {
+	Job      string
+	Start    time.Time
+	End      time.Time
+	Step     time.Duration
+	Findings []Finding
+}
+
+// Bottlenecks are the resources with a critical, or failing that a warning,
+// utilization or saturation finding, the most severe first.
+func (r *Report) Bottlenecks() []Finding {
+	flagged := []Finding{}
+	for _, f := range r.Findings {
+		if f.Check.Signal != Utilization && f.Check.Signal != Saturation {
+			continue
+		}
+		if severity(f.Level) > 0 {
+			flagged = append(flagged, f)
+		}
+	}
+	sort.SliceStable(flagged, func(i, j int) bool {
+		if severity(flagged[i].Level) != severity(flagged[j].Level) {
+			return severity(flagged[i].Level) > severity(flagged[j].Level)
+		}
+		// saturation is the stronger signal of a bottleneck
+		return flagged[i].Check.Signal == Saturation && flagged[j].Check.Signal != Saturation
+	})
+	return flagged
+}
+
+// Anomalies are every finding that isn't ok.
+func (r *Report) Anomalies() []Finding {
+	flagged := []Finding{}
+	for _, f := range r.Findings {
+		if severity(f.Level) > 0 {
+			flagged = append(flagged, f)
+		}
+	}
+	return flagged
+}
+
+// Analyze runs every check over [start, end] and collects a finding per
+// returned series, checks without any series are recorded as no data.
+func Analyze(ctx context.Context, c *promapi.Client, checks []Check, job string,
+	start time.Time, end time.Time, step time.Duration) (*Report, error) {
+
+	r := &Report{
+		Job:   job,
+		Start: start,
+		End:   end,
+		Step:  step,
+	}
+	for _, check := range checks {
+		if err := dashboard.ValidateExpr(check.Query); err != nil {
+			return nil, fmt.Errorf("check %s %s: %s", check.Resource, check.Name, err)
+		}
+
+		series, err := c.QueryRange(ctx, check.Query, start, end, step)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(series) == 0 {
+			r.Findings = append(r.Findings, Finding{
+				Check: check,
+				Level: LevelNoData,
+			})
+			continue
+		}
+		for _, s := range series {
+			stats := NewStats(s.Samples)
+			level, notes := evaluate(check, stats)
+			r.Findings = append(r.Findings, Finding{
+				Check:  check,
+				Series: seriesName(s.Labels),
+				Stats:  stats,
+				Level:  level,
+				Notes:  notes,
+			})
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,365 @@
+package analysis
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	Utilization = "utilization"
+	Saturation  = "saturation"
+	Errors      = "errors"
+	Rate        = "rate"
+	Duration    = "duration"
+)
+
+// Check is a single USE or RED signal.  A series is flagged as a warning or
+// critical when its p95 over the range crosses Warn or Critical, zero
+// thresholds are never crossed.  Trend checks additionally flag series that
+// grow steadily, the shape of a leak.
+type Check struct {
+	Resource string
+	Signal   string
+	Name     string
+	Query    string
+	Unit     string
+	Warn     float64
+	Critical float64
+	Trend    bool
+	// Hint explains what a flagged check usually means.
+	Hint string
+}
+
+// Options select the service and node exporter series the default checks
+// query.
+type Options struct {
+	Job              string
+	NodeJob          string
+	RateWindow       time.Duration
+	LatencyThreshold float64
+}
+
+func promDuration(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%ds", int(d/time.Second))
+}
+
+// DefaultChecks are the USE checks of the host, cgroup, process, go runtime,
+// database pool and postgres, and the RED checks of the service.
+func DefaultChecks(o Options) []Check {
+	job := fmt.Sprintf(`job=%q`, o.Job)
+	node := fmt.Sprintf(`job=%q`, o.NodeJob)
+	w := promDuration(o.RateWindow)
+
+	return []Check{
+		{
+			Resource: "CPU",
+			Signal:   Utilization,
+			Name:     "busy ratio",
+			Query:    fmt.Sprintf(`1 - avg(rate(node_cpu_seconds_total{%s, mode="idle"}[%s]))`, node, w),
+			Unit:     "ratio",
+			Warn:     0.7,
+			Critical: 0.9,
+			Hint:     "the host is CPU bound, profile the server with /debug/pprof/profile",
+		},
+		{
+			Resource: "CPU",
+			Signal:   Saturation,
+			Name:     "load per cpu",
+			Query: fmt.Sprintf(`node_load1{%s} / scalar(count(node_cpu_seconds_total{%s, mode="idle"}))`,
+				node, node),
+			Warn:     1,
+			Critical: 2,
+			Hint:     "more runnable tasks than cpus, work is queueing for the cpu",
+		},
+		{
+			Resource: "CPU",
+			Signal:   Saturation,
+			Name:     "cgroup throttled periods",
+			Query: fmt.Sprintf(`rate(container_cpu_cfs_throttled_periods_total{%s}[%s]) `+
+				`/ rate(container_cpu_cfs_periods_total{%s}[%s])`, job, w, job, w),
+			Unit:     "ratio",
+			Warn:     0.1,
+			Critical: 0.25,
+			Hint:     "the server is using its whole cpu quota and is being throttled, latency grows even though the host has idle cpu",
+		},
+		{
+			Resource: "CPU",
+			Signal:   Saturation,
+			Name:     "cpu pressure stall",
+			Query:    fmt.Sprintf(`rate(pressure_stall_seconds_total{%s, resource="cpu", kind="some"}[%s])`, job, w),
+			Unit:     "ratio",
+			Warn:     0.1,
+			Critical: 0.25,
+			Hint:     "runnable tasks are waiting for a cpu",
+		},
+		{
+			Resource: "Memory",
+			Signal:   Utilization,
+			Name:     "used ratio",
+			Query: fmt.Sprintf(`1 - node_memory_MemAvailable_bytes{%s} / node_memory_MemTotal_bytes{%s}`,
+				node, node),
+			Unit:     "ratio",
+			Warn:     0.8,
+			Critical: 0.95,
+			Hint:     "the host is close to running out of memory",
+		},
+		{
+			Resource: "Memory",
+			Signal:   Saturation,
+			Name:     "major page faults",
+			Query:    fmt.Sprintf(`rate(node_vmstat_pgmajfault{%s}[%s])`, node, w),
+			Unit:     "/s",
+			Warn:     10,
+			Critical: 100,
+			Hint:     "pages are being read back from disk, the host is swapping or thrashing the page cache",
+		},
+		{
+			Resource: "Memory",
+			Signal:   Utilization,
+			Name:     "cgroup used of limit",
+			Query:    fmt.Sprintf(`container_memory_usage_bytes{%s} / container_memory_limit_bytes{%s}`, job, job),
+			Unit:     "ratio",
+			Warn:     0.8,
+			Critical: 0.95,
+			Hint:     "the server's container is close to its memory limit and will be oom killed",
+		},
+		{
+			Resource: "Memory",
+			Signal:   Saturation,
+			Name:     "memory pressure stall",
+			Query:    fmt.Sprintf(`rate(pressure_stall_seconds_total{%s, resource="memory", kind="some"}[%s])`, job, w),
+			Unit:     "ratio",
+			Warn:     0.05,
+			Critical: 0.2,
+			Hint:     "tasks are stalled reclaiming memory",
+		},
+		{
+			Resource: "Memory",
+			Signal:   Utilization,
+			Name:     "server resident memory",
+			Query:    fmt.Sprintf(`process_resident_memory_bytes{%s}`, job),
+			Unit:     "bytes",
+			Trend:    true,
+			Hint:     "resident memory keeps growing, compare /debug/pprof/heap profiles for a leak",
+		},
+		{
+			Resource: "File Descriptors",
+			Signal:   Utilization,
+			Name:     "open ratio",
+			Query:    fmt.Sprintf(`process_open_fds{%s} / process_max_fds{%s}`, job, job),
+			Unit:     "ratio",
+			Warn:     0.5,
+			Critical: 0.8,
+			Trend:    true,
+			Hint:     "descriptors are leaking, check for unclosed rows, response bodies and files",
+		},
+		{
+			Resource: "Goroutines",
+			Signal:   Utilization,
+			Name:     "goroutines",
+			Query:    fmt.Sprintf(`go_goroutines{%s}`, job),
+			Trend:    true,
+			Hint:     "goroutines keep growing, compare /debug/pprof/goroutine dumps for a leak",
+		},
+		{
+			Resource: "Go GC",
+			Signal:   Saturation,
+			Name:     "gc time ratio",
+			Query:    fmt.Sprintf(`rate(go_gc_duration_seconds_sum{%s}[%s])`, job, w),
+			Unit:     "ratio",
+			Warn:     0.05,
+			Critical: 0.2,
+			Hint:     "the garbage collector is taking a large share of cpu, reduce allocations",
+		},
+		{
+			Resource: "Go Scheduler",
+			Signal:   Saturation,
+			Name:     "p99 scheduling latency",
+			Query: fmt.Sprintf(`histogram_quantile(0.99, sum(rate(go_sched_latencies_seconds_bucket{%s}[%s])) by (le))`,
+				job, w),
+			Unit:     "s",
+			Warn:     0.001,
+			Critical: 0.01,
+			Hint:     "runnable goroutines are waiting for a P, the server needs more cpu or GOMAXPROCS is too low",
+		},
+		{
+			Resource: "Go Scheduler",
+			Signal:   Saturation,
+			Name:     "mutex wait",
+			Query:    fmt.Sprintf(`rate(go_sync_mutex_wait_total_seconds_total{%s}[%s])`, job, w),
+			Unit:     "s/s",
+			Warn:     0.1,
+			Critical: 1,
+			Hint:     "goroutines are blocked on a contended lock, see /debug/pprof/mutex",
+		},
+		{
+			Resource: "DB Pool",
+			Signal:   Utilization,
+			Name:     "in use ratio",
+			Query: fmt.Sprintf(`db_in_use_connections{%s} / clamp_min(db_open_connections{%s}, 1)`,
+				job, job),
+			Unit:     "ratio",
+			Warn:     0.8,
+			Critical: 0.95,
+			Hint:     "nearly every open connection is busy",
+		},
+		{
+			Resource: "DB Pool",
+			Signal:   Saturation,
+			Name:     "waits for a connection",
+			Query:    fmt.Sprintf(`rate(db_wait_count_total{%s}[%s])`, job, w),
+			Unit:     "/s",
+			Warn:     0.1,
+			Critical: 1,
+			Hint:     "requests are queueing for a database connection, the pool is too small or queries are too slow",
+		},
+		{
+			Resource: "DB Pool",
+			Signal:   Errors,
+			Name:     "find by age errors",
+			Query:    fmt.Sprintf(`sum(rate(find_by_age_seconds_count{%s, status="error"}[%s]))`, job, w),
+			Unit:     "/s",
+			Warn:     0.01,
+			Critical: 1,
+			Hint:     "queries are failing, check the server logs and postgres",
+		},
+		{
+			Resource: "Postgres",
+			Signal:   Utilization,
+			Name:     "sequential scan ratio",
+			Query: fmt.Sprintf(`sum(rate(pg_stat_user_tables_seq_scan_total{%s}[%s])) by (relname) `+
+				`/ (sum(rate(pg_stat_user_tables_seq_scan_total{%s}[%s])) by (relname) `+
+				`+ sum(rate(pg_stat_user_tables_idx_scan_total{%s}[%s])) by (relname))`, job, w, job, w, job, w),
+			Unit:     "ratio",
+			Warn:     0.5,
+			Critical: 0.9,
+			Hint:     "queries are reading the whole table, check the WHERE columns with EXPLAIN for a missing index",
+		},
+		{
+			Resource: "Postgres",
+			Signal:   Utilization,
+			Name:     "buffer cache miss ratio",
+			Query: fmt.Sprintf(`rate(pg_stat_database_blks_read_total{%s}[%s]) `+
+				`/ (rate(pg_stat_database_blks_hit_total{%s}[%s]) + rate(pg_stat_database_blks_read_total{%s}[%s]))`,
+				job, w, job, w, job, w),
+			Unit:     "ratio",
+			Warn:     0.1,
+			Critical: 0.3,
+			Hint:     "blocks are read from disk instead of shared_buffers",
+		},
+		{
+			Resource: "Postgres",
+			Signal:   Saturation,
+			Name:     "waiting locks",
+			Query:    fmt.Sprintf(`sum(pg_locks_count{%s, granted="false"})`, job),
+			Warn:     1,
+			Critical: 5,
+			Hint:     "queries are queueing on locks held by other transactions",
+		},
+		{
+			Resource: "Postgres",
+			Signal:   Errors,
+			Name:     "deadlocks",
+			Query:    fmt.Sprintf(`rate(pg_stat_database_deadlocks_total{%s}[%s])`, job, w),
+			Unit:     "/s",
+			Warn:     0.001,
+			Critical: 0.1,
+			Hint:     "transactions are deadlocking and being aborted",
+		},
+		{
+			Resource: "Postgres",
+			Signal:   Saturation,
+			Name:     "retries denied by the budget",
+			Query:    fmt.Sprintf(`sum(rate(resilience_retries_total{%s, result="budget_exhausted"}[%s]))`, job, w),
+			Unit:     "/s",
+			Warn:     0.01,
+			Critical: 1,
+			Hint:     "failures exceed the retry budget, retrying would add load to a struggling postgres",
+		},
+		{
+			Resource: "Postgres",
+			Signal:   Errors,
+			Name:     "short circuited queries",
+			Query:    fmt.Sprintf(`sum(rate(resilience_short_circuited_total{%s}[%s]))`, job, w),
+			Unit:     "/s",
+			Warn:     0.01,
+			Critical: 1,
+			Hint:     "the circuit breaker is open, queries are failing fast because postgres keeps failing",
+		},
+		{
+			Resource: "Change Feed",
+			Signal:   Saturation,
+			Name:     "p99 notification lag",
+			Query: fmt.Sprintf(`histogram_quantile(0.99, sum(rate(changefeed_notification_lag_seconds_bucket{%s}[%s])) by (le))`,
+				job, w),
+			Unit:     "s",
+			Warn:     0.5,
+			Critical: 5,
+			Hint:     "notifications are delivered late, look for long running transactions or a slow listener",
+		},
+		{
+			Resource: "Change Feed",
+			Signal:   Errors,
+			Name:     "listener disconnects",
+			Query:    fmt.Sprintf(`rate(changefeed_listener_events_total{%s, event="disconnected"}[%s])`, job, w),
+			Unit:     "/s",
+			Warn:     0.001,
+			Critical: 0.1,
+			Hint:     "the listener is losing its postgres connection, notifications sent while it reconnects are lost",
+		},
+		{
+			Resource: "Service",
+			Signal:   Rate,
+			Name:     "requests",
+			Query:    fmt.Sprintf(`sum(rate(http_request_seconds_count{%s}[%s]))`, job, w),
+			Unit:     "/s",
+		},
+		{
+			Resource: "Service",
+			Signal:   Errors,
+			Name:     "find by age error ratio",
+			Query: fmt.Sprintf(`sum(rate(find_by_age_seconds_count{%s, status="error"}[%s])) `+
+				`/ sum(rate(find_by_age_seconds_count{%s}[%s]))`, job, w, job, w),
+			Unit:     "ratio",
+			Warn:     0.01,
+			Critical: 0.05,
+			Hint:     "a large share of requests are failing",
+		},
+		{
+			Resource: "Service",
+			Signal:   Duration,
+			Name:     "p50 latency",
+			Query: fmt.Sprintf(`histogram_quantile(0.50, sum(rate(http_request_seconds_bucket{%s}[%s])) by (le))`,
+				job, w),
+			Unit:     "s",
+			Warn:     o.LatencyThreshold / 2,
+			Critical: o.LatencyThreshold,
+			Hint:     "typical requests are slow, the bottleneck affects every request",
+		},
+		{
+			Resource: "Service",
+			Signal:   Duration,
+			Name:     "p99 latency",
+			Query: fmt.Sprintf(`histogram_quantile(0.99, sum(rate(http_request_seconds_bucket{%s}[%s])) by (le))`,
+				job, w),
+			Unit:     "s",
+			Warn:     o.LatencyThreshold,
+			Critical: o.LatencyThreshold * 2,
+			Hint:     "tail latency is high, look for queueing on a saturated resource",
+		},
+		{
+			Resource: "Service",
+			Signal:   Duration,
+			Name:     "find by age p99 latency",
+			Query: fmt.Sprintf(`histogram_quantile(0.99, sum(rate(find_by_age_seconds_bucket{%s}[%s])) by (le))`,
+				job, w),
+			Unit:     "s",
+			Warn:     o.LatencyThreshold,
+			Critical: o.LatencyThreshold * 2,
+			Hint:     "the database query dominates request latency, EXPLAIN the FindByAge query",
+		},
+	}
+}
@@ -0,0 +1,127 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+func formatValue(v float64, unit string) string {
+	switch unit {
+	case "ratio":
+		return fmt.Sprintf("%.1f%%", v*100)
+	case "s":
+		return time.Duration(v * float64(time.Second)).Round(10 * time.Microsecond).String()
+	case "bytes":
+		return fmt.Sprintf("%.1fMiB", v/(1<<20))
+	}
+	return fmt.Sprintf("%.3g%s", v, unit)
+}
+
+var levelMarker = map[string]string{
+	LevelOK:       "ok",
+	LevelWarn:     "**warn**",
+	LevelCritical: "**CRITICAL**",
+	LevelNoData:   "no data",
+}
+
+func name(f Finding) string {
+	if f.Series == "" {
+		return f.Check.Name
+	}
+	return fmt.Sprintf("%s `{%s}`", f.Check.Name, f.Series)
+}
+
+func writeTable(w io.Writer, findings []Finding) {
+	fmt.Fprintln(w, "| Resource | Signal | Check | Min | Mean | P95 | Max | Status |")
+	fmt.Fprintln(w, "|---|---|---|---|---|---|---|---|")
+	for _, f := range findings {
+		col := f.Check.Resource
+		if f.Level == LevelNoData {
+			fmt.Fprintf(w, "| %s | %s | %s | | | | | %s |\n",
+				col, f.Check.Signal, name(f), levelMarker[f.Level])
+			continue
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+			col, f.Check.Signal, name(f),
+			formatValue(f.Stats.Min, f.Check.Unit),
+			formatValue(f.Stats.Mean, f.Check.Unit),
+			formatValue(f.Stats.P95, f.Check.Unit),
+			formatValue(f.Stats.Max, f.Check.Unit),
+			levelMarker[f.Level])
+	}
+	fmt.Fprintln(w)
+}
+
+// WriteMarkdown writes the report as markdown, the bottlenecks and
+// anomalies come first followed by the USE and RED tables.
+func (r *Report) WriteMarkdown(out io.Writer) error {
+	w := &bytes.Buffer{}
+
+	fmt.Fprintf(w, "# USE/RED analysis of job %q\n\n", r.Job)
+	fmt.Fprintf(w, "- Range: %s to %s (%s)\n", r.Start.UTC().Format(time.RFC3339),
+		r.End.UTC().Format(time.RFC3339), r.End.Sub(r.Start))
+	fmt.Fprintf(w, "- Step: %s\n", r.Step)
+
+	counts := map[string]int{}
+	for _, f := range r.Findings {
+		counts[f.Level] += 1
+	}
+	fmt.Fprintf(w, "- Findings: %d critical, %d warnings, %d ok, %d without data\n\n",
+		counts[LevelCritical], counts[LevelWarn], counts[LevelOK], counts[LevelNoData])
+
+	fmt.Fprintln(w, "## Likely bottlenecks")
+	fmt.Fprintln(w)
+	bottlenecks := r.Bottlenecks()
+	if len(bottlenecks) == 0 {
+		fmt.Fprintln(w, "No resource crossed a utilization or saturation threshold.")
+	}
+	for i, f := range bottlenecks {
+		fmt.Fprintf(w, "%d. **%s %s** (%s): %s, %s.\n", i+1, f.Check.Resource, f.Check.Signal,
+			f.Level, strings.Join(f.Notes, ", "), f.Check.Hint)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "## Anomalies")
+	fmt.Fprintln(w)
+	anomalies := r.Anomalies()
+	if len(anomalies) == 0 {
+		fmt.Fprintln(w, "None.")
+	}
+	for _, f := range anomalies {
+		fmt.Fprintf(w, "- %s %s: %s %s.\n", levelMarker[f.Level], f.Check.Resource, name(f),
+			strings.Join(f.Notes, ", "))
+	}
+	fmt.Fprintln(w)
+
+	use := []Finding{}
+	red := []Finding{}
+	for _, f := range r.Findings {
+		switch f.Check.Signal {
+		case Utilization, Saturation:
+			use = append(use, f)
+		case Errors:
+			// errors of a resource are USE, the service's own errors are RED
+			if f.Check.Resource == "Service" {
+				red = append(red, f)
+			} else {
+				use = append(use, f)
+			}
+		default:
+			red = append(red, f)
+		}
+	}
+
+	fmt.Fprintln(w, "## USE")
+	fmt.Fprintln(w)
+	writeTable(w, use)
+
+	fmt.Fprintln(w, "## RED")
+	fmt.Fprintln(w)
+	writeTable(w, red)
+
+	_, err := w.WriteTo(out)
+	return err
+}
@@ -0,0 +1,253 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// v1 reports no limit as the largest page aligned int64
+const unlimited = math.MaxInt64 &^ 4095
+
+// Cgroup locates the cpu and memory controllers of the process, either
+// the unified cgroup v2 hierarchy or the v1 cpu and memory hierarchies.
+type Cgroup struct {
+	Version   int
+	cpuDir    string
+	memoryDir string
+}
+
+// CPU is the cfs bandwidth control of the cgroup.  Quota is in cores, zero
+// when the cgroup isn't limited.
+type CPU struct {
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    time.Duration
+	Quota            float64
+}
+
+// Memory is the cgroup's memory usage, Limit is zero when it isn't limited.
+type Memory struct {
+	Usage uint64
+	Limit uint64
+}
+
+// controllerDir is the directory of the process's cgroup under mount, or
+// mount itself when the process's path isn't visible, which is usually the
+// case inside of a container's cgroup namespace.
+func controllerDir(mount string, path string) string {
+	dir := filepath.Join(mount, path)
+	if _, err := os.Stat(dir); err != nil {
+		return mount
+	}
+	return dir
+}
+
+// Detect finds the cgroup of the current process from /proc/self/cgroup.
+func Detect() (*Cgroup, error) {
+	return detect("/proc/self/cgroup", "/sys/fs/cgroup")
+}
+
+func detect(procCgroup string, root string) (*Cgroup, error) {
+	f, err := os.Open(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// hierarchy-ID:controller-list:cgroup-path
+	paths := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		dir := controllerDir(root, paths[""])
+		return &Cgroup{Version: 2, cpuDir: dir, memoryDir: dir}, nil
+	}
+
+	cpu, ok := paths["cpu"]
+	if !ok {
+		return nil, fmt.Errorf("no cpu cgroup in %s", procCgroup)
+	}
+	memory, ok := paths["memory"]
+	if !ok {
+		return nil, fmt.Errorf("no memory cgroup in %s", procCgroup)
+	}
+	return &Cgroup{
+		Version:   1,
+		cpuDir:    controllerDir(filepath.Join(root, "cpu"), cpu),
+		memoryDir: controllerDir(filepath.Join(root, "memory"), memory),
+	}, nil
+}
+
+func readUint(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// readKeyValues reads flat keyed files like cpu.stat, "key value" per line.
+func readKeyValues(path string) (map[string]uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		values[fields[0]] = v
+	}
+	return values, nil
+}
+
+// Quota is the cgroup's cpu limit in cores, zero when it isn't limited.
+func (c *Cgroup) Quota() (float64, error) {
+	if c.Version == 2 {
+		// cpu.max is "$MAX $PERIOD", $MAX is "max" when unlimited
+		b, err := ioutil.ReadFile(filepath.Join(c.cpuDir, "cpu.max"))
+		if err != nil {
+			return 0, err
+		}
+		fields := strings.Fields(string(b))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, nil
+		}
+		max, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, err
+		}
+		period, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || period == 0 {
+			return 0, fmt.Errorf("invalid cpu.max period %q", fields[1])
+		}
+		return max / period, nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(c.cpuDir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, err
+	}
+	quota, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || quota <= 0 {
+		return 0, err
+	}
+	period, err := readUint(filepath.Join(c.cpuDir, "cpu.cfs_period_us"))
+	if err != nil || period == 0 {
+		return 0, fmt.Errorf("invalid cpu.cfs_period_us: %v", err)
+	}
+	return float64(quota) / float64(period), nil
+}
+
+func (c *Cgroup) CPU() (CPU, error) {
+	stat, err := readKeyValues(filepath.Join(c.cpuDir, "cpu.stat"))
+	if err != nil {
+		return CPU{}, err
+	}
+	cpu := CPU{
+		Periods:          stat["nr_periods"],
+		ThrottledPeriods: stat["nr_throttled"],
+	}
+	if c.Version == 2 {
+		cpu.ThrottledTime = time.Duration(stat["throttled_usec"]) * time.Microsecond
+	} else {
+		cpu.ThrottledTime = time.Duration(stat["throttled_time"])
+	}
+
+	cpu.Quota, err = c.Quota()
+	return cpu, err
+}
+
+func (c *Cgroup) Memory() (Memory, error) {
+	usageFile, limitFile := "memory.usage_in_bytes", "memory.limit_in_bytes"
+	if c.Version == 2 {
+		usageFile, limitFile = "memory.current", "memory.max"
+	}
+
+	usage, err := readUint(filepath.Join(c.memoryDir, usageFile))
+	if err != nil {
+		return Memory{}, err
+	}
+	m := Memory{Usage: usage}
+
+	b, err := ioutil.ReadFile(filepath.Join(c.memoryDir, limitFile))
+	if err != nil {
+		return m, err
+	}
+	limit := strings.TrimSpace(string(b))
+	if limit == "max" {
+		return m, nil
+	}
+	if m.Limit, err = strconv.ParseUint(limit, 10, 64); err != nil {
+		return m, err
+	}
+	if m.Limit >= unlimited {
+		m.Limit = 0
+	}
+	return m, nil
+}
+
+// Pressure is a line of a /proc/pressure file, Kind is some, the share of
+// time at least one task was stalled, or full, the share of time every
+// task was stalled.
+type Pressure struct {
+	Kind  string
+	Total time.Duration
+}
+
+// ReadPressure reads the pressure stall information of resource, one of
+// cpu, memory or io.  It's unavailable on kernels before 4.20 or without
+// CONFIG_PSI.
+func ReadPressure(resource string) ([]Pressure, error) {
+	b, err := ioutil.ReadFile(filepath.Join("/proc/pressure", resource))
+	if err != nil {
+		return nil, err
+	}
+
+	// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+	pressures := []Pressure{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		p := Pressure{Kind: fields[0]}
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "total=") {
+				continue
+			}
+			total, err := strconv.ParseUint(strings.TrimPrefix(f, "total="), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("/proc/pressure/%s: %s", resource, err)
+			}
+			p.Total = time.Duration(total) * time.Microsecond
+		}
+		pressures = append(pressures, p)
+	}
+	return pressures, nil
+}
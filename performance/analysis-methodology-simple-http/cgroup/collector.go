@@ -0,0 +1,127 @@
+package cgroup
+
+import (
+	"log"
+	"math"
+	"os"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	periodsDesc = prometheus.NewDesc(
+		"container_cpu_cfs_periods_total",
+		"# of cfs enforcement periods of the cgroup",
+		nil, nil)
+	throttledPeriodsDesc = prometheus.NewDesc(
+		"container_cpu_cfs_throttled_periods_total",
+		"# of cfs periods the cgroup used its whole quota and was throttled",
+		nil, nil)
+	throttledSecondsDesc = prometheus.NewDesc(
+		"container_cpu_cfs_throttled_seconds_total",
+		"Total time the cgroup's tasks were throttled",
+		nil, nil)
+	quotaDesc = prometheus.NewDesc(
+		"container_cpu_quota_cores",
+		"cfs quota of the cgroup in cores, absent when unlimited",
+		nil, nil)
+	memoryUsageDesc = prometheus.NewDesc(
+		"container_memory_usage_bytes",
+		"Memory used by the cgroup, including the page cache",
+		nil, nil)
+	memoryLimitDesc = prometheus.NewDesc(
+		"container_memory_limit_bytes",
+		"Memory limit of the cgroup, absent when unlimited",
+		nil, nil)
+	pressureDesc = prometheus.NewDesc(
+		"pressure_stall_seconds_total",
+		"Total time tasks were stalled on the resource, from /proc/pressure. kind=some|full",
+		[]string{"resource", "kind"}, nil)
+)
+
+// Collector exports the cgroup's cpu throttling and memory usage against
+// its limit, and the host's pressure stall information.  These are the
+// saturation of a container, which the node exporter's host wide metrics
+// don't show.
+type Collector struct {
+	cgroup *Cgroup
+}
+
+// NewCollector detects the process's cgroup, when it can't be found only
+// the pressure stall information is collected.
+func NewCollector() *Collector {
+	c := &Collector{}
+	cg, err := Detect()
+	if err != nil {
+		log.Printf("cgroup metrics disabled: %s", err)
+		return c
+	}
+	c.cgroup = cg
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- periodsDesc
+	ch <- throttledPeriodsDesc
+	ch <- throttledSecondsDesc
+	ch <- quotaDesc
+	ch <- memoryUsageDesc
+	ch <- memoryLimitDesc
+	ch <- pressureDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.cgroup != nil {
+		if cpu, err := c.cgroup.CPU(); err == nil {
+			ch <- prometheus.MustNewConstMetric(periodsDesc, prometheus.CounterValue, float64(cpu.Periods))
+			ch <- prometheus.MustNewConstMetric(throttledPeriodsDesc, prometheus.CounterValue, float64(cpu.ThrottledPeriods))
+			ch <- prometheus.MustNewConstMetric(throttledSecondsDesc, prometheus.CounterValue, cpu.ThrottledTime.Seconds())
+			if cpu.Quota > 0 {
+				ch <- prometheus.MustNewConstMetric(quotaDesc, prometheus.GaugeValue, cpu.Quota)
+			}
+		}
+		if m, err := c.cgroup.Memory(); err == nil {
+			ch <- prometheus.MustNewConstMetric(memoryUsageDesc, prometheus.GaugeValue, float64(m.Usage))
+			if m.Limit > 0 {
+				ch <- prometheus.MustNewConstMetric(memoryLimitDesc, prometheus.GaugeValue, float64(m.Limit))
+			}
+		}
+	}
+
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		pressures, err := ReadPressure(resource)
+		if err != nil {
+			continue
+		}
+		for _, p := range pressures {
+			ch <- prometheus.MustNewConstMetric(pressureDesc, prometheus.CounterValue, p.Total.Seconds(), resource, p.Kind)
+		}
+	}
+}
+
+// SetMaxProcs sets GOMAXPROCS to the cgroup's cpu quota, rounded up, when
+// it's lower than the number of cpus.  Go defaults GOMAXPROCS to the cpus
+// of the host, a container limited to fewer cores then burns through its
+// quota with more threads than it can run and gets throttled.  An explicit
+// GOMAXPROCS environment variable is left alone.  It returns the
+// GOMAXPROCS in effect.
+func SetMaxProcs() int {
+	if _, ok := os.LookupEnv("GOMAXPROCS"); ok {
+		return runtime.GOMAXPROCS(0)
+	}
+	cg, err := Detect()
+	if err != nil {
+		return runtime.GOMAXPROCS(0)
+	}
+	quota, err := cg.Quota()
+	if err != nil || quota <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+
+	procs := int(math.Ceil(quota))
+	if procs < runtime.NumCPU() {
+		runtime.GOMAXPROCS(procs)
+	}
+	return runtime.GOMAXPROCS(0)
+}
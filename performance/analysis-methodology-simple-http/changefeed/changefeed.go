@@ -0,0 +1,346 @@
+package changefeed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Channel is notified by the people_notify trigger of the people
+// migrations.
+const Channel = "people_changes"
+
+var (
+	listenerEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "changefeed_listener_events_total",
+		Help: "Listener connection events, event=connected|disconnected|reconnected|connection_attempt_failed",
+	}, []string{"event"})
+
+	listenerConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "changefeed_listener_connected",
+		Help: "1 while the listener is connected to postgres",
+	})
+
+	notifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "changefeed_notifications_total",
+		Help: "Notifications received, op=INSERT|UPDATE|DELETE|invalid",
+	}, []string{"op"})
+
+	notificationLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "changefeed_notification_lag_seconds",
+		Help:    "Time from a row changing to the server receiving its notification, includes the time until the change's transaction committed",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+
+	subscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "changefeed_subscribers",
+		Help: "# of clients streaming changes",
+	})
+
+	dropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "changefeed_dropped_subscribers_total",
+		Help: "Subscribers disconnected because they fell behind the feed",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(listenerEvents)
+	prometheus.MustRegister(listenerConnected)
+	prometheus.MustRegister(notifications)
+	prometheus.MustRegister(notificationLag)
+	prometheus.MustRegister(subscribers)
+	prometheus.MustRegister(dropped)
+}
+
+// Change is a row of people that was inserted, updated or deleted.
+type Change struct {
+	Op              string    `json:"op"`
+	Address         string    `json:"address"`
+	FullName        string    `json:"full_name"`
+	Age             int       `json:"age"`
+	LastUpdatedTime time.Time `json:"last_updated_time"`
+	// At is when the row changed, it's zero for changes replayed from
+	// the table.
+	At time.Time `json:"at,omitempty"`
+}
+
+// ID identifies a change to resume from, clients send it back as
+// Last-Event-ID.  The rows of a transaction share their
+// last_updated_time so the row is part of the id.
+func (c Change) ID() string {
+	return url.Values{
+		"last_updated_time": {c.LastUpdatedTime.UTC().Format(time.RFC3339Nano)},
+		"full_name":         {c.FullName},
+		"address":           {c.Address},
+	}.Encode()
+}
+
+// parseID is the inverse of ID, a bare RFC3339 time resumes from that time
+// without skipping any row.
+func parseID(id string) (Change, error) {
+	if t, err := time.Parse(time.RFC3339Nano, id); err == nil {
+		return Change{LastUpdatedTime: t}, nil
+	}
+
+	v, err := url.ParseQuery(id)
+	if err != nil {
+		return Change{}, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, v.Get("last_updated_time"))
+	if err != nil {
+		return Change{}, err
+	}
+	return Change{
+		FullName:        v.Get("full_name"),
+		Address:         v.Get("address"),
+		LastUpdatedTime: t,
+	}, nil
+}
+
+func (c Change) sameRow(o Change) bool {
+	return c.FullName == o.FullName && c.Address == o.Address && c.LastUpdatedTime.Equal(o.LastUpdatedTime)
+}
+
+// Feed fans the notifications of a pq.Listener out to subscribers.
+// Notifications sent while the listener is disconnected are lost,
+// subscribers that resume replay the rows updated since then from the
+// table instead, so deleted rows aren't replayed.
+type Feed struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	mu          sync.Mutex
+	subscribers map[chan Change]bool
+}
+
+func New(db *sql.DB, dbConnectionString string, minReconnect time.Duration, maxReconnect time.Duration) *Feed {
+	listener := pq.NewListener(dbConnectionString, minReconnect, maxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			listenerEvents.WithLabelValues("connected").Inc()
+			listenerConnected.Set(1)
+		case pq.ListenerEventDisconnected:
+			listenerEvents.WithLabelValues("disconnected").Inc()
+			listenerConnected.Set(0)
+			log.Printf("change feed listener disconnected: %s", err)
+		case pq.ListenerEventReconnected:
+			listenerEvents.WithLabelValues("reconnected").Inc()
+			listenerConnected.Set(1)
+		case pq.ListenerEventConnectionAttemptFailed:
+			listenerEvents.WithLabelValues("connection_attempt_failed").Inc()
+			log.Printf("change feed listener connection attempt failed: %s", err)
+		}
+	})
+	return &Feed{
+		db:          db,
+		listener:    listener,
+		subscribers: map[chan Change]bool{},
+	}
+}
+
+// Run listens for changes until ctx is done.  The connection is pinged
+// when no notifications arrive so a dead connection is noticed.
+func (f *Feed) Run(ctx context.Context) error {
+	if err := f.listener.Listen(Channel); err != nil {
+		return err
+	}
+	defer f.listener.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case n := <-f.listener.Notify:
+			// nil is sent after a reconnect
+			if n != nil {
+				f.notify(n.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			go f.listener.Ping()
+		}
+	}
+}
+
+func (f *Feed) notify(payload string) {
+	received := time.Now()
+
+	c := Change{}
+	if err := json.Unmarshal([]byte(payload), &c); err != nil {
+		notifications.WithLabelValues("invalid").Inc()
+		log.Printf("decoding change %q: %s", payload, err)
+		return
+	}
+	notifications.WithLabelValues(c.Op).Inc()
+	notificationLag.Observe(received.Sub(c.At).Seconds())
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		select {
+		case ch <- c:
+		default:
+			// a subscriber that can't keep up is disconnected rather than
+			// silently missing changes, it can resume from its last id
+			delete(f.subscribers, ch)
+			close(ch)
+			subscribers.Dec()
+			dropped.Inc()
+		}
+	}
+}
+
+// Subscribe returns a channel of changes, it's closed if the subscriber
+// falls behind.  cancel must be called when the subscriber is done.
+func (f *Feed) Subscribe(buffer int) (<-chan Change, func()) {
+	ch := make(chan Change, buffer)
+
+	f.mu.Lock()
+	f.subscribers[ch] = true
+	f.mu.Unlock()
+	subscribers.Inc()
+
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.subscribers[ch] {
+			delete(f.subscribers, ch)
+			close(ch)
+			subscribers.Dec()
+		}
+	}
+}
+
+// Since returns the rows inserted or updated since last, oldest first.
+// The rest of last's transaction is replayed too, every row of a
+// transaction has the same last_updated_time and a client may have been
+// disconnected partway through one, so only last itself is skipped.
+func (f *Feed) Since(ctx context.Context, last Change) ([]Change, error) {
+	rows, err := f.db.QueryContext(ctx, `
+		SELECT address, full_name, age, last_updated_time
+		FROM people
+		WHERE last_updated_time >= $1
+		ORDER BY last_updated_time`, last.LastUpdatedTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		c := Change{Op: "UPSERT"}
+		if err := rows.Scan(&c.Address, &c.FullName, &c.Age, &c.LastUpdatedTime); err != nil {
+			return nil, err
+		}
+		if c.sameRow(last) {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func writeEvent(w http.ResponseWriter, c Change) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: change\ndata: %s\n\n", c.ID(), b)
+	return err
+}
+
+// Handler streams changes as server-sent events.  Clients resume with the
+// Last-Event-ID header, which EventSource sends when it reconnects, or
+// ?since=<RFC3339 time>, the rows updated since then are replayed as
+// UPSERT changes before the live changes.
+//
+// Resuming is best effort.  last_updated_time is when a row's transaction
+// started, a transaction that commits after a client's last event can hold
+// rows older than it which aren't replayed, and rows of the last event's
+// transaction may be sent twice.
+func (f *Feed) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "expected GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		since := r.Header.Get("Last-Event-ID")
+		if since == "" {
+			since = r.FormValue("since")
+		}
+		var last Change
+		if since != "" {
+			var err error
+			if last, err = parseID(since); err != nil {
+				http.Error(w, fmt.Sprintf("invalid since: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// subscribe before replaying so nothing changed during the replay
+		// is missed
+		changes, cancel := f.Subscribe(256)
+		defer cancel()
+
+		var replayed []Change
+		if since != "" {
+			var err error
+			if replayed, err = f.Since(r.Context(), last); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// the stream outlives the server's WriteTimeout
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		replayedAt := map[[2]string]time.Time{}
+		for _, c := range replayed {
+			if err := writeEvent(w, c); err != nil {
+				return
+			}
+			replayedAt[[2]string{c.FullName, c.Address}] = c.LastUpdatedTime
+		}
+		rc.Flush()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case c, ok := <-changes:
+				if !ok {
+					return
+				}
+				// already replayed
+				at, ok := replayedAt[[2]string{c.FullName, c.Address}]
+				if ok && c.Op != "DELETE" && c.LastUpdatedTime.Equal(at) {
+					continue
+				}
+				if err := writeEvent(w, c); err != nil {
+					return
+				}
+			}
+			rc.Flush()
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dm03514/analysis-methodology-simple-http/analysis"
+	"github.com/dm03514/analysis-methodology-simple-http/promapi"
+)
+
+func parseTime(s string, def time.Time) time.Time {
+	if s == "" {
+		return def
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		log.Fatalf("invalid time %q, expected RFC3339: %s", s, err)
+	}
+	return t
+}
+
+func main() {
+	promURL := flag.String("prometheus-url", "http://localhost:9090", "")
+	job := flag.String("job", "server", "prometheus job of the service")
+	nodeJob := flag.String("node-job", "node", "prometheus job of the node exporter")
+	startStr := flag.String("start", "", "RFC3339 start of the range, defaults to -range before -end")
+	endStr := flag.String("end", "", "RFC3339 end of the range, defaults to now")
+	queryRange := flag.Duration("range", 15*time.Minute, "")
+	step := flag.Duration("step", 15*time.Second, "")
+	rateWindow := flag.Duration("rate-window", time.Minute, "window of the rate() in each check")
+	latencyThreshold := flag.Float64("latency-threshold", 0.060, "seconds, p99 over this is flagged")
+	out := flag.String("out", "", "path the markdown report is written to, defaults to stdout")
+	flag.Parse()
+
+	end := parseTime(*endStr, time.Now())
+	start := parseTime(*startStr, end.Add(-*queryRange))
+
+	checks := analysis.DefaultChecks(analysis.Options{
+		Job:              *job,
+		NodeJob:          *nodeJob,
+		RateWindow:       *rateWindow,
+		LatencyThreshold: *latencyThreshold,
+	})
+
+	client := promapi.NewClient(*promURL, 30*time.Second)
+	report, err := analysis.Analyze(context.Background(), client, checks, *job, start, end, *step)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report.WriteMarkdown(w); err != nil {
+		log.Fatal(err)
+	}
+}
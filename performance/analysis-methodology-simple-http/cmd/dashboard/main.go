@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+
+	"github.com/dm03514/analysis-methodology-simple-http/dashboard"
+)
+
+func main() {
+	out := flag.String("out", "config/dashboards/service.json", "path the dashboard is written to")
+	datasource := flag.String("datasource", "Prom", "grafana datasource the panels query")
+	latencyThreshold := flag.Float64("latency-threshold", 0.060, "seconds, drawn on the request latency panel")
+	serverURL := flag.String("server-url", "http://localhost:8080", "where the browser reaches the server's debug endpoints")
+	check := flag.Bool("check", false, "exit non zero if -out isn't up to date instead of writing it")
+	flag.Parse()
+
+	d := dashboard.Service(*datasource, *latencyThreshold, *serverURL)
+	b, err := d.JSON()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b = append(b, '\n')
+
+	if *check {
+		current, err := ioutil.ReadFile(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !bytes.Equal(current, b) {
+			log.Fatalf("%q is out of date, regenerate it with: make dashboard", *out)
+		}
+		return
+	}
+
+	if err := ioutil.WriteFile(*out, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote dashboard to %q", *out)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dm03514/analysis-methodology-simple-http/analysis"
+	"github.com/dm03514/analysis-methodology-simple-http/loadtest"
+	"github.com/dm03514/analysis-methodology-simple-http/promapi"
+)
+
+func writeResults(path string, results []loadtest.Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"start", "latency_seconds", "code", "error"})
+	for _, r := range results {
+		w.Write([]string{
+			r.Start.UTC().Format(time.RFC3339Nano),
+			strconv.FormatFloat(r.Latency.Seconds(), 'f', 6, 64),
+			strconv.Itoa(r.Code),
+			r.Error,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeAnalysis(ctx context.Context, path string, promURL string, job string, latencyThreshold float64,
+	start time.Time, end time.Time) error {
+
+	checks := analysis.DefaultChecks(analysis.Options{
+		Job:              job,
+		NodeJob:          "node",
+		RateWindow:       time.Minute,
+		LatencyThreshold: latencyThreshold,
+	})
+	report, err := analysis.Analyze(ctx, promapi.NewClient(promURL, 30*time.Second),
+		checks, job, start, end, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteMarkdown(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	profilesPath := flag.String("profiles", "config/loadprofiles.json", "")
+	profileName := flag.String("profile", "steady", "name of the load profile to run")
+	runID := flag.String("run-id", "", "defaults to <profile>-<start time>")
+	runsDir := flag.String("runs-dir", "runs", "each run is stored under <runs-dir>/<run-id>")
+	serverURL := flag.String("server-url", "http://localhost:8080", "server the pprof profiles are taken from")
+	promURL := flag.String("prometheus-url", "http://localhost:9090", "")
+	job := flag.String("job", "server", "prometheus job of the server")
+	cpuProfile := flag.Duration("cpu-profile", 20*time.Second, "length of the cpu profile taken from the middle "+
+		"of the run, it must be shorter than the server's WriteTimeout, 0 disables it")
+	maxConns := flag.Int("max-conns", 100, "idle connections kept to the target")
+	latencyThreshold := flag.Float64("latency-threshold", 0.060, "seconds, flagged in the server side analysis")
+	baselinePath := flag.String("baseline", "", "summary.json of a baseline run to compare against")
+	saveBaseline := flag.String("save-baseline", "", "path the summary of this run is saved to as a baseline")
+	maxP99 := flag.Float64("max-p99-regression", 0.2, "allowed p99 increase over the baseline, as a fraction")
+	maxThroughput := flag.Float64("max-throughput-regression", 0.1, "allowed throughput decrease, as a fraction")
+	maxSuccessRate := flag.Float64("max-success-rate-regression", 0.01, "allowed success rate decrease, as a fraction")
+	flag.Parse()
+
+	profiles, err := loadtest.LoadProfiles(*profilesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	profile, err := profiles.Get(*profileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var baseline *loadtest.Summary
+	if *baselinePath != "" {
+		if baseline, err = loadtest.LoadSummary(*baselinePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *runID == "" {
+		*runID = fmt.Sprintf("%s-%s", profile.Name, time.Now().UTC().Format("20060102T150405"))
+	}
+	dir := filepath.Join(*runsDir, *runID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	profiler := loadtest.NewProfiler(*serverURL)
+
+	wg := sync.WaitGroup{}
+	if *cpuProfile > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// centre the profile on the run so it skips the warm up
+			wait := (profile.Duration() - *cpuProfile) / 2
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+			if err := profiler.CPU(ctx, *cpuProfile, filepath.Join(dir, "cpu.pprof")); err != nil {
+				log.Printf("cpu profile failed: %s", err)
+			}
+		}()
+	}
+
+	log.Printf("run %q: profile %q for %s against %s", *runID, profile.Name, profile.Duration(), profile.Target)
+	start := time.Now()
+	results := loadtest.NewAttacker(profile.TimeoutDuration(), *maxConns).Attack(ctx, profile)
+	// Attack waits for the requests still in flight after the last stage,
+	// the run ends when the last stage was scheduled to so a slow drain
+	// doesn't lower the throughput.
+	end := start.Add(profile.Duration())
+	wg.Wait()
+
+	if err := profiler.Heap(ctx, filepath.Join(dir, "heap.pprof")); err != nil {
+		log.Printf("heap profile failed: %s", err)
+	}
+	if err := profiler.Goroutine(ctx, filepath.Join(dir, "goroutine.pprof")); err != nil {
+		log.Printf("goroutine profile failed: %s", err)
+	}
+
+	if err := writeResults(filepath.Join(dir, "results.csv"), results); err != nil {
+		log.Fatal(err)
+	}
+
+	summary := loadtest.NewSummary(*runID, profile.Name, start, end, results)
+	if err := summary.Write(filepath.Join(dir, "summary.json")); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("requests: %d, success rate: %.4f, throughput: %.2f/s, p50: %.4fs, p99: %.4fs",
+		summary.Requests, summary.SuccessRate, summary.Throughput, summary.Latencies.P50, summary.Latencies.P99)
+
+	// the server side view is best effort, prometheus isn't required to
+	// gate on the client side numbers
+	err = writeAnalysis(ctx, filepath.Join(dir, "analysis.md"), *promURL, *job, *latencyThreshold, start, end)
+	if err != nil {
+		log.Printf("server side analysis failed: %s", err)
+	}
+
+	if *saveBaseline != "" {
+		if err := summary.Write(*saveBaseline); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("saved baseline %q", *saveBaseline)
+	}
+
+	log.Printf("run stored in %q", dir)
+	if baseline == nil {
+		return
+	}
+
+	regressions := loadtest.Compare(*baseline, summary, loadtest.Thresholds{
+		P99:         *maxP99,
+		Throughput:  *maxThroughput,
+		SuccessRate: *maxSuccessRate,
+	})
+	for _, r := range regressions {
+		log.Printf("REGRESSION against %q: %s", baseline.RunID, r)
+	}
+	if len(regressions) > 0 {
+		os.Exit(1)
+	}
+	log.Printf("no regressions against baseline %q", baseline.RunID)
+}
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/dm03514/analysis-methodology-simple-http/cgroup"
+	"github.com/dm03514/analysis-methodology-simple-http/changefeed"
+	"github.com/dm03514/analysis-methodology-simple-http/dberr"
+	"github.com/dm03514/analysis-methodology-simple-http/migrate"
+	"github.com/dm03514/analysis-methodology-simple-http/migrations"
+	"github.com/dm03514/analysis-methodology-simple-http/pathology"
+	"github.com/dm03514/analysis-methodology-simple-http/pgstats"
+	"github.com/dm03514/analysis-methodology-simple-http/resilience"
+	"github.com/dm03514/analysis-methodology-simple-http/runtimemetrics"
+	"github.com/dm03514/analysis-methodology-simple-http/slowquery"
 	_ "github.com/lib/pq"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -12,16 +24,11 @@ import (
 	"log"
 	"net/http"
 	"net/http/pprof"
+	"runtime"
+	"strings"
 	"time"
 )
 
-func errToStatus(err error) string {
-	if err != nil {
-		return "error"
-	}
-	return "success"
-}
-
 var (
 	requestLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "http_request_seconds",
@@ -30,8 +37,8 @@ var (
 
 	findByAgeLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "find_by_age_seconds",
-		Help: "Distribution of find by age durations, status=success|error",
-	}, []string{"status"})
+		Help: "Distribution of find by age durations, status=success|client_error|canceled|error, class is the dberr.Class of errors",
+	}, []string{"status", "class"})
 
 	findByAgeResultCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "find_by_age_results_count",
@@ -46,7 +53,9 @@ func init() {
 }
 
 type Postgres struct {
-	db *sql.DB
+	db          *sql.DB
+	slowQueries *slowquery.Log
+	policy      *resilience.Policy
 }
 
 type PeopleResponse struct {
@@ -64,7 +73,8 @@ type Payload struct {
 }
 
 type Handler struct {
-	Postgres *Postgres
+	Postgres  *Postgres
+	Pathology *pathology.Injector
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -76,29 +86,35 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		requestLatency.WithLabelValues(html.EscapeString(r.URL.Path)).Observe(diff.Seconds())
 	}()
 
+	h.Pathology.Inject()
+
 	payload := Payload{}
 	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
 		msg := fmt.Sprintf("received: %q.  Expected message of format %+v",
 			err, Payload{})
-		http.Error(w, msg, http.StatusInternalServerError)
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 
 	findStart := time.Now()
-	people, err := h.Postgres.FindByAge(payload.Age)
+	people, err := h.Postgres.FindByAge(r.Context(), payload.Age)
 
 	findByAgeLatency.WithLabelValues(
-		errToStatus(err),
+		dberr.Status(err),
+		dberr.ClassOf(err),
 	).Observe(
 		time.Since(findStart).Seconds(),
 	)
 
-	findByAgeResultCount.WithLabelValues(errToStatus(err)).Set(float64(len(people)))
+	findByAgeResultCount.WithLabelValues(dberr.Status(err)).Set(float64(len(people)))
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		if dberr.IsRetryable(err) {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, err.Error(), dberr.HTTPStatus(err))
 		return
 	}
 
@@ -109,33 +125,113 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(&resp)
 }
 
-func (p *Postgres) FindByAge(age int) ([]Person, error) {
+// FindByAge retries retryable errors and fails fast while postgres is
+// failing, through p's resilience policy.
+func (p *Postgres) FindByAge(ctx context.Context, age int) ([]Person, error) {
+	var people []Person
+	err := p.policy.Do(ctx, func(ctx context.Context) error {
+		var err error
+		people, err = p.findByAge(ctx, age)
+		return err
+	})
+	if errors.Is(err, resilience.ErrOpen) {
+		return nil, &dberr.Error{Class: dberr.Unavailable, Err: err}
+	}
+	return people, err
+}
+
+func (p *Postgres) findByAge(ctx context.Context, age int) ([]Person, error) {
 	people := []Person{}
 
 	q := `SELECT address, full_name, age FROM people WHERE age = $1`
 
-	rows, err := p.db.Query(q, age)
+	start := time.Now()
+	defer func() {
+		p.slowQueries.Observe("find_by_age", q, []interface{}{age}, time.Since(start))
+	}()
+
+	rows, err := p.db.QueryContext(ctx, q, age)
 	if err != nil {
-		return people, nil
+		return nil, dberr.Classify(err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		person := Person{}
 
-		if err := rows.Scan(&person.Address, &person.FullName, &person.Address); err != nil {
-			return people, err
+		if err := rows.Scan(&person.Address, &person.FullName, &person.Age); err != nil {
+			return nil, dberr.Classify(err)
 		}
 
 		people = append(people, person)
 	}
 
 	if err := rows.Err(); err != nil {
-		return people, err
+		return nil, dberr.Classify(err)
 	}
 
 	return people, nil
 }
 
+// registerDBStats exports the connection pool stats of db, the USE of the
+// database pool.
+func registerDBStats(db *sql.DB) {
+	gauges := map[string]func(sql.DBStats) float64{
+		"db_open_connections":     func(s sql.DBStats) float64 { return float64(s.OpenConnections) },
+		"db_in_use_connections":   func(s sql.DBStats) float64 { return float64(s.InUse) },
+		"db_idle_connections":     func(s sql.DBStats) float64 { return float64(s.Idle) },
+		"db_max_open_connections": func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) },
+	}
+	for name, f := range gauges {
+		f := f
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: name,
+			Help: "sql.DBStats of the postgres connection pool",
+		}, func() float64 {
+			return f(db.Stats())
+		}))
+	}
+
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "db_wait_count_total",
+		Help: "# of times a query waited for a free connection",
+	}, func() float64 {
+		return float64(db.Stats().WaitCount)
+	}))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "db_wait_duration_seconds_total",
+		Help: "Total time queries spent waiting for a free connection",
+	}, func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	}))
+}
+
+// checkSchemaVersion verifies the database has every people migration
+// embedded in the binary applied.
+func checkSchemaVersion(db *sql.DB) error {
+	ms, err := migrations.Load("people")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return migrate.New(db, "people", ms).Check(ctx)
+}
+
+// startPGStats polls postgres's statistics views on a connection of its own
+// so the polling doesn't show up in the server's pool stats.
+func startPGStats(dbConnectionString string, tables []string, interval time.Duration) error {
+	db, err := sql.Open("postgres", dbConnectionString)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(1)
+
+	c := pgstats.NewCollector(db, tables)
+	prometheus.MustRegister(c)
+	go c.Run(context.Background(), interval)
+	return nil
+}
+
 func NewPostges(dbConnectionString string) (*Postgres, error) {
 	db, err := sql.Open("postgres", dbConnectionString)
 	// db.SetMaxOpenConns(8)
@@ -165,24 +261,135 @@ func AttachProfiler(router *http.ServeMux) {
 	router.Handle("/debug/pprof/heap", pprof.Handler("heap"))
 	router.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 	router.Handle("/debug/pprof/block", pprof.Handler("block"))
+	router.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
 }
 
 func main() {
 	dbConnectionString := flag.String("db-connection-string", "", "")
+	pathologies := flag.String("pathology", "", fmt.Sprintf(
+		"comma separated mode=rate:size pathologies injected into requests, modes: %s",
+		strings.Join(pathology.Modes(), "|")))
+	dbMaxAttempts := flag.Int("db-max-attempts", 3, "# of times a query is attempted when it fails with a retryable error, 1 disables retries")
+	dbRetryBase := flag.Duration("db-retry-base", 25*time.Millisecond, "ceiling of the jittered delay before the first retry, it doubles every retry")
+	dbRetryMax := flag.Duration("db-retry-max", time.Second, "maximum ceiling of the jittered delay between retries")
+	dbRetryBudget := flag.Float64("db-retry-budget", 0.1, "retries are limited to this fraction of queries")
+	dbRetryMinPerSecond := flag.Float64("db-retry-min-per-second", 1, "retries per second allowed regardless of the retry budget")
+	dbBreakerFailures := flag.Int("db-breaker-failures", 5, "# of consecutive failed queries that opens the circuit breaker")
+	dbBreakerOpen := flag.Duration("db-breaker-open", 5*time.Second, "how long the open circuit breaker fails queries fast before probing postgres")
+	pgStatsInterval := flag.Duration("pg-stats-interval", 0,
+		"how often postgres's statistics views are polled for metrics, 0 disables them")
+	pgStatsTables := flag.String("pg-stats-tables", "people", "comma separated tables whose scans are exported")
+	slowQueryThreshold := flag.Duration("slow-query-threshold", 50*time.Millisecond,
+		"queries slower than this are logged to /debug/slowqueries, 0 disables the slow query log")
+	slowQueryExplainRate := flag.Float64("slow-query-explain-rate", 0.1,
+		"fraction of slow queries that are run again with EXPLAIN (ANALYZE, BUFFERS)")
+	checkSchema := flag.Bool("check-schema", true, "exit at startup unless every people migration is applied")
+	slowQueryRecent := flag.Int("slow-query-recent", 100, "# of slow queries kept for /debug/slowqueries")
+	changeFeed := flag.Bool("change-feed", true, "stream changes to people at /people/changes, requires a postgres connection of its own")
+	changeFeedMaxReconnect := flag.Duration("change-feed-max-reconnect", time.Minute,
+		"maximum backoff between change feed listener reconnects")
 	flag.Parse()
 
+	// with no attempts the policy never runs the query and returns no
+	// error, the rest would leave the budget or the breaker meaningless
+	switch {
+	case *dbMaxAttempts < 1:
+		log.Fatalf("-db-max-attempts must be at least 1, received: %d", *dbMaxAttempts)
+	case *dbRetryBudget < 0:
+		log.Fatalf("-db-retry-budget must not be negative, received: %f", *dbRetryBudget)
+	case *dbRetryMinPerSecond < 0:
+		log.Fatalf("-db-retry-min-per-second must not be negative, received: %f", *dbRetryMinPerSecond)
+	case *dbBreakerFailures < 1:
+		log.Fatalf("-db-breaker-failures must be at least 1, received: %d", *dbBreakerFailures)
+	case *dbBreakerOpen <= 0:
+		log.Fatalf("-db-breaker-open must be positive, received: %s", *dbBreakerOpen)
+	}
+
+	fmt.Printf("gomaxprocs: %d\n", cgroup.SetMaxProcs())
+	prometheus.MustRegister(cgroup.NewCollector())
+	prometheus.MustRegister(runtimemetrics.NewCollector())
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "go_sched_gomaxprocs_threads",
+		Help: "GOMAXPROCS, the # of threads that can execute go code simultaneously",
+	}, func() float64 {
+		return float64(runtime.GOMAXPROCS(0))
+	}))
+
+	injector := pathology.NewInjector()
+	settings, err := pathology.ParseSettings(*pathologies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for m, s := range settings {
+		injector.Set(m, s)
+		fmt.Printf("pathology: %q, rate: %v, size: %d\n", m, s.Rate, s.Size)
+	}
+
 	postgres, err := NewPostges(*dbConnectionString)
 	if err != nil {
 		panic(err)
 	}
+	registerDBStats(postgres.db)
+
+	postgres.policy = resilience.NewPolicy(
+		"postgres",
+		*dbMaxAttempts,
+		resilience.NewBackoff(*dbRetryBase, *dbRetryMax),
+		resilience.NewBudget(*dbRetryBudget, *dbRetryMinPerSecond),
+		resilience.NewBreaker(*dbBreakerFailures, *dbBreakerOpen),
+		dberr.IsRetryable,
+		// client errors say nothing about the health of postgres
+		func(err error) bool {
+			return dberr.Status(err) == "error"
+		},
+	)
+
+	if *checkSchema {
+		if err := checkSchemaVersion(postgres.db); err != nil {
+			log.Fatalf("%s, apply the migrations with: make migrate-up, "+
+				"databases created before the migrations are adopted first with: make migrate-baseline", err)
+		}
+	}
+
+	if *slowQueryThreshold > 0 {
+		explainDB, err := sql.Open("postgres", *dbConnectionString)
+		if err != nil {
+			log.Fatal(err)
+		}
+		explainDB.SetMaxOpenConns(1)
+		postgres.slowQueries, err = slowquery.New(explainDB, *slowQueryThreshold, *slowQueryExplainRate, *slowQueryRecent)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *pgStatsInterval > 0 {
+		if err := startPGStats(*dbConnectionString, strings.Split(*pgStatsTables, ","), *pgStatsInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var feed *changefeed.Feed
+	if *changeFeed {
+		feed = changefeed.New(postgres.db, *dbConnectionString, time.Second, *changeFeedMaxReconnect)
+		go func() {
+			log.Fatal(feed.Run(context.Background()))
+		}()
+	}
 
 	h := &Handler{
-		Postgres: postgres,
+		Postgres:  postgres,
+		Pathology: injector,
 	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
 	AttachProfiler(mux)
+	mux.Handle("/debug/pathology", injector.Handler())
+	mux.Handle("/debug/slowqueries", postgres.slowQueries.Handler())
+	if feed != nil {
+		mux.Handle("/people/changes", feed.Handler())
+	}
 	mux.Handle("/", h)
 
 	s := &http.Server{
@@ -1,13 +1,5 @@
 #!/usr/bin/env sh
 
+# the tables are created by the migrations in migrations/people, which the
+# server applies with cmd/migrate before it starts
 psql -c "create database people"
-
-psql -c "create schema people"
-
-psql people -c "CREATE TABLE people (
-    last_updated_time TIMESTAMP WITH TIME ZONE default current_timestamp,
-    address text NOT NULL,
-    full_name text NOT NULL,
-    age integer NOT NULL,
-    PRIMARY KEY (full_name, address)
-)"
@@ -0,0 +1,321 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	panelHeight  = 7
+	panelWidth   = 12
+	gridColumns  = 24
+	intervalName = "interval"
+)
+
+// Target is a single PromQL query drawn on a panel.
+type Target struct {
+	Expr   string
+	Legend string
+}
+
+// Link is a link shown in a panel's header, ie to a debug endpoint with
+// the details behind the graph.
+type Link struct {
+	Title string
+	URL   string
+}
+
+// Panel is a graph panel.  Series whose legend is listed in RightAxis are
+// drawn against a second y axis formatted as RightUnit.  Threshold draws a
+// critical line at that value when it's non zero.
+type Panel struct {
+	Title       string
+	Description string
+	Unit        string
+	RightUnit   string
+	RightAxis   []string
+	Stack       bool
+	Threshold   float64
+	Links       []Link
+	Targets     []Target
+}
+
+// Row groups panels under a header, panels are laid out two per line.
+type Row struct {
+	Title  string
+	Panels []Panel
+}
+
+// Variable is a templating variable populated from a label_values query.
+// IncludeAll variables default to matching every value and should be used
+// with =~.
+type Variable struct {
+	Name       string
+	Label      string
+	Query      string
+	Default    string
+	IncludeAll bool
+}
+
+type Dashboard struct {
+	Title       string
+	Description string
+	UID         string
+	Datasource  string
+	Variables   []Variable
+	// Intervals are the choices of the $interval variable, the first is
+	// the default.
+	Intervals []string
+	Rows      []Row
+}
+
+var variableRef = regexp.MustCompile(`\$\{?(\w+)\}?`)
+
+// grafana's own variables, these are always defined
+var builtinVariables = map[string]bool{
+	"__interval":      true,
+	"__interval_ms":   true,
+	"__range":         true,
+	"__rate_interval": true,
+}
+
+// expand replaces variables with a representative value so the expression
+// can be validated, durations for interval variables and the default, or
+// .* for all, for everything else.
+func (d *Dashboard) expand(expr string) (string, error) {
+	values := map[string]string{
+		intervalName: "1m",
+	}
+	for name := range builtinVariables {
+		values[name] = "1m"
+	}
+	for _, v := range d.Variables {
+		values[v.Name] = v.Default
+		if v.IncludeAll || v.Default == "" {
+			values[v.Name] = ".*"
+		}
+	}
+
+	var err error
+	expanded := variableRef.ReplaceAllStringFunc(expr, func(ref string) string {
+		name := variableRef.FindStringSubmatch(ref)[1]
+		v, ok := values[name]
+		if !ok {
+			err = fmt.Errorf("undefined variable %q", ref)
+		}
+		return v
+	})
+	return expanded, err
+}
+
+// Validate checks that every panel has targets and that every expression
+// parses as PromQL and only references defined variables.
+func (d *Dashboard) Validate() error {
+	if d.Title == "" || d.UID == "" {
+		return fmt.Errorf("dashboard requires a title and a uid")
+	}
+	if len(d.Intervals) == 0 {
+		return fmt.Errorf("dashboard %q requires at least one interval", d.Title)
+	}
+	for _, r := range d.Rows {
+		for _, p := range r.Panels {
+			if len(p.Targets) == 0 {
+				return fmt.Errorf("panel %q has no targets", p.Title)
+			}
+			for _, t := range p.Targets {
+				expr, err := d.expand(t.Expr)
+				if err != nil {
+					return fmt.Errorf("panel %q: %s", p.Title, err)
+				}
+				if err := ValidateExpr(expr); err != nil {
+					return fmt.Errorf("panel %q: %q: %s", p.Title, t.Expr, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Dashboard) templating() templating {
+	t := templating{List: []variable{}}
+	for _, v := range d.Variables {
+		ds := d.Datasource
+		gv := variable{
+			Current:    option{Text: v.Default, Value: v.Default},
+			Datasource: &ds,
+			IncludeAll: v.IncludeAll,
+			Label:      v.Label,
+			Name:       v.Name,
+			Options:    []option{},
+			Query:      v.Query,
+			Refresh:    1,
+			Sort:       1,
+			Type:       "query",
+		}
+		if v.IncludeAll {
+			all := ".*"
+			gv.AllValue = &all
+			if v.Default == "" {
+				gv.Current = option{Text: "All", Value: "$__all"}
+			}
+		}
+		t.List = append(t.List, gv)
+	}
+
+	interval := variable{
+		Current: option{Text: d.Intervals[0], Value: d.Intervals[0]},
+		Name:    intervalName,
+		Query:   strings.Join(d.Intervals, ","),
+		Refresh: 2,
+		Type:    "interval",
+	}
+	for i, v := range d.Intervals {
+		interval.Options = append(interval.Options, option{
+			Selected: i == 0,
+			Text:     v,
+			Value:    v,
+		})
+	}
+	t.List = append(t.List, interval)
+	return t
+}
+
+func (d *Dashboard) graph(p Panel) grafanaPanel {
+	unit := p.Unit
+	if unit == "" {
+		unit = "short"
+	}
+	rightUnit := p.RightUnit
+	if rightUnit == "" {
+		rightUnit = "short"
+	}
+
+	gp := grafanaPanel{
+		Datasource:    d.Datasource,
+		Description:   p.Description,
+		Fill:          1,
+		Legend:        &legend{Show: true},
+		Lines:         true,
+		Linewidth:     1,
+		NullPointMode: "null",
+		Stack:         p.Stack,
+		Title:         p.Title,
+		Tooltip:       &tooltip{Shared: true, ValueType: "individual"},
+		Type:          "graph",
+		Xaxis:         &xaxis{Mode: "time", Show: true},
+		Yaxes: []yaxis{
+			{Format: unit, LogBase: 1, Show: true},
+			{Format: rightUnit, LogBase: 1, Show: len(p.RightAxis) > 0},
+		},
+	}
+	for i, t := range p.Targets {
+		gp.Targets = append(gp.Targets, grafanaTarget{
+			Expr:           t.Expr,
+			Format:         "time_series",
+			IntervalFactor: 1,
+			LegendFormat:   t.Legend,
+			RefID:          string(rune('A' + i)),
+		})
+	}
+	for _, l := range p.Links {
+		gp.Links = append(gp.Links, panelLink{
+			TargetBlank: true,
+			Title:       l.Title,
+			URL:         l.URL,
+		})
+	}
+	for _, alias := range p.RightAxis {
+		gp.SeriesOverrides = append(gp.SeriesOverrides, seriesOverride{
+			Alias: alias,
+			Yaxis: 2,
+		})
+	}
+	if p.Threshold != 0 {
+		gp.Thresholds = []threshold{{
+			ColorMode: "critical",
+			Fill:      false,
+			Line:      true,
+			Op:        "gt",
+			Value:     p.Threshold,
+		}}
+	}
+	return gp
+}
+
+// JSON validates the dashboard and renders it as json that can be loaded by
+// grafana's file provisioning.
+func (d *Dashboard) JSON() ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	gd := grafanaDashboard{
+		Annotations: annotations{
+			List: []annotation{{
+				BuiltIn:    1,
+				Datasource: "-- Grafana --",
+				Enable:     true,
+				Hide:       true,
+				IconColor:  "rgba(0, 211, 255, 1)",
+				Name:       "Annotations & Alerts",
+				Type:       "dashboard",
+			}},
+		},
+		Description:   d.Description,
+		Editable:      true,
+		Links:         []interface{}{},
+		Refresh:       false,
+		SchemaVersion: 16,
+		Style:         "dark",
+		Tags:          []string{},
+		Templating:    d.templating(),
+		Time:          map[string]string{"from": "now-15m", "to": "now"},
+		Timezone:      "browser",
+		Title:         d.Title,
+		UID:           d.UID,
+		Version:       1,
+	}
+
+	id := 1
+	y := 0
+	collapsed := false
+	for _, r := range d.Rows {
+		gd.Panels = append(gd.Panels, grafanaPanel{
+			Collapsed: &collapsed,
+			GridPos:   gridPos{H: 1, W: gridColumns, X: 0, Y: y},
+			ID:        id,
+			Title:     r.Title,
+			Type:      "row",
+		})
+		id += 1
+		y += 1
+
+		for i, p := range r.Panels {
+			gp := d.graph(p)
+			gp.ID = id
+			gp.GridPos = gridPos{
+				H: panelHeight,
+				W: panelWidth,
+				X: (i % 2) * panelWidth,
+				Y: y + (i/2)*panelHeight,
+			}
+			gd.Panels = append(gd.Panels, gp)
+			id += 1
+		}
+		y += ((len(r.Panels) + 1) / 2) * panelHeight
+	}
+
+	return json.MarshalIndent(gd, "", "  ")
+}
+
+func (d *Dashboard) Write(w io.Writer) error {
+	b, err := d.JSON()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,146 @@
+package dashboard
+
+// The types below are the subset of grafana's (schemaVersion 16) dashboard
+// json that the generated dashboards use.
+
+type grafanaDashboard struct {
+	Annotations   annotations       `json:"annotations"`
+	Description   string            `json:"description"`
+	Editable      bool              `json:"editable"`
+	GraphTooltip  int               `json:"graphTooltip"`
+	Links         []interface{}     `json:"links"`
+	Panels        []grafanaPanel    `json:"panels"`
+	Refresh       interface{}       `json:"refresh"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Style         string            `json:"style"`
+	Tags          []string          `json:"tags"`
+	Templating    templating        `json:"templating"`
+	Time          map[string]string `json:"time"`
+	Timezone      string            `json:"timezone"`
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	Version       int               `json:"version"`
+}
+
+type annotation struct {
+	BuiltIn    int    `json:"builtIn"`
+	Datasource string `json:"datasource"`
+	Enable     bool   `json:"enable"`
+	Hide       bool   `json:"hide"`
+	IconColor  string `json:"iconColor"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+}
+
+type annotations struct {
+	List []annotation `json:"list"`
+}
+
+type option struct {
+	Selected bool   `json:"selected"`
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+}
+
+type variable struct {
+	AllValue   *string  `json:"allValue"`
+	Current    option   `json:"current"`
+	Datasource *string  `json:"datasource"`
+	Hide       int      `json:"hide"`
+	IncludeAll bool     `json:"includeAll"`
+	Label      string   `json:"label"`
+	Multi      bool     `json:"multi"`
+	Name       string   `json:"name"`
+	Options    []option `json:"options"`
+	Query      string   `json:"query"`
+	Refresh    int      `json:"refresh"`
+	Regex      string   `json:"regex"`
+	Sort       int      `json:"sort"`
+	Type       string   `json:"type"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	Expr           string `json:"expr"`
+	Format         string `json:"format"`
+	IntervalFactor int    `json:"intervalFactor"`
+	LegendFormat   string `json:"legendFormat,omitempty"`
+	RefID          string `json:"refId"`
+}
+
+type legend struct {
+	Show bool `json:"show"`
+}
+
+type xaxis struct {
+	Mode string `json:"mode"`
+	Show bool   `json:"show"`
+}
+
+type yaxis struct {
+	Format  string   `json:"format"`
+	LogBase int      `json:"logBase"`
+	Max     *float64 `json:"max"`
+	Min     *float64 `json:"min"`
+	Show    bool     `json:"show"`
+}
+
+type panelLink struct {
+	TargetBlank bool   `json:"targetBlank"`
+	Title       string `json:"title"`
+	URL         string `json:"url"`
+}
+
+type seriesOverride struct {
+	Alias string `json:"alias"`
+	Yaxis int    `json:"yaxis"`
+}
+
+type threshold struct {
+	ColorMode string  `json:"colorMode"`
+	Fill      bool    `json:"fill"`
+	Line      bool    `json:"line"`
+	Op        string  `json:"op"`
+	Value     float64 `json:"value"`
+}
+
+type tooltip struct {
+	Shared    bool   `json:"shared"`
+	Sort      int    `json:"sort"`
+	ValueType string `json:"value_type"`
+}
+
+// grafanaPanel is either a graph or, when Type is "row", a row header.
+type grafanaPanel struct {
+	Collapsed       *bool            `json:"collapsed,omitempty"`
+	Datasource      string           `json:"datasource,omitempty"`
+	Description     string           `json:"description,omitempty"`
+	Fill            int              `json:"fill,omitempty"`
+	GridPos         gridPos          `json:"gridPos"`
+	ID              int              `json:"id"`
+	Legend          *legend          `json:"legend,omitempty"`
+	Links           []panelLink      `json:"links,omitempty"`
+	Lines           bool             `json:"lines,omitempty"`
+	Linewidth       int              `json:"linewidth,omitempty"`
+	NullPointMode   string           `json:"nullPointMode,omitempty"`
+	Panels          []grafanaPanel   `json:"panels,omitempty"`
+	SeriesOverrides []seriesOverride `json:"seriesOverrides,omitempty"`
+	Stack           bool             `json:"stack,omitempty"`
+	Targets         []grafanaTarget  `json:"targets,omitempty"`
+	Thresholds      []threshold      `json:"thresholds,omitempty"`
+	Title           string           `json:"title"`
+	Tooltip         *tooltip         `json:"tooltip,omitempty"`
+	Type            string           `json:"type"`
+	Xaxis           *xaxis           `json:"xaxis,omitempty"`
+	Yaxes           []yaxis          `json:"yaxes,omitempty"`
+}
@@ -0,0 +1,617 @@
+package dashboard
+
+import "fmt"
+
+const (
+	serviceSelector = `job="$job", instance=~"$instance"`
+	nodeSelector    = `job="node", instance=~"$node"`
+)
+
+func quantiles(metric string, selector string) []Target {
+	targets := []Target{}
+	for _, q := range []struct {
+		quantile string
+		legend   string
+	}{
+		{"0.50", "p50"},
+		{"0.95", "p95"},
+		{"0.99", "p99"},
+		{"1", "max"},
+	} {
+		targets = append(targets, Target{
+			Expr: fmt.Sprintf("histogram_quantile(%s, sum(rate(%s_bucket{%s}[$interval])) by (le))",
+				q.quantile, metric, selector),
+			Legend: q.legend,
+		})
+	}
+	return targets
+}
+
+// RED are the rate, errors and duration panels of the service's http
+// handler and its FindByAge query.  latencyThreshold, in seconds, is drawn
+// on the request latency panel.  The query panels link to the slow query
+// log of the server at serverURL.
+func RED(latencyThreshold float64, serverURL string) Row {
+	slowQueries := []Link{{
+		Title: "Slow queries and plans",
+		URL:   serverURL + "/debug/slowqueries?format=text",
+	}}
+
+	return Row{
+		Title: "RED",
+		Panels: []Panel{
+			{
+				Title: "HTTP Request Rate",
+				Unit:  "reqps",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("sum(rate(http_request_seconds_count{%s}[$interval])) by (path)", serviceSelector),
+					Legend: "{{path}}",
+				}},
+			},
+			{
+				Title:     "HTTP Request Latency",
+				Unit:      "s",
+				Threshold: latencyThreshold,
+				Targets:   quantiles("http_request_seconds", serviceSelector),
+			},
+			{
+				Title: "Find By Age Rate",
+				Unit:  "ops",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("sum(rate(find_by_age_seconds_count{%s}[$interval])) by (status, class)", serviceSelector),
+					Legend: "{{status}} {{class}}",
+				}},
+			},
+			{
+				Title: "Find By Age Error Ratio",
+				Unit:  "percentunit",
+				Targets: []Target{{
+					Expr: fmt.Sprintf("sum(rate(find_by_age_seconds_count{%s, status=\"error\"}[$interval])) "+
+						"/ sum(rate(find_by_age_seconds_count{%s}[$interval]))", serviceSelector, serviceSelector),
+					Legend: "errors",
+				}},
+			},
+			{
+				Title:   "Find By Age Latency",
+				Unit:    "s",
+				Links:   slowQueries,
+				Targets: quantiles("find_by_age_seconds", serviceSelector),
+			},
+			{
+				Title: "Slow Queries",
+				Unit:  "ops",
+				Links: slowQueries,
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("sum(rate(slow_queries_total{%s}[$interval])) by (name)", serviceSelector),
+					Legend: "{{name}}",
+				}},
+			},
+			{
+				Title: "Find By Age # Results Returned",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("find_by_age_results_count{%s}", serviceSelector),
+					Legend: "{{status}}",
+				}},
+			},
+		},
+	}
+}
+
+// USE are the utilization, saturation and errors panels of the host, from
+// the node exporter, of the server's cgroup and of its database pool.
+func USE() Row {
+	return Row{
+		Title: "USE",
+		Panels: []Panel{
+			{
+				Title: "CPU Utilization",
+				Unit:  "percentunit",
+				Stack: true,
+				Targets: []Target{{
+					Expr: fmt.Sprintf("sum(rate(node_cpu_seconds_total{%s, mode!=\"idle\"}[$interval])) by (mode) "+
+						"/ scalar(count(node_cpu_seconds_total{%s, mode=\"idle\"}))", nodeSelector, nodeSelector),
+					Legend: "{{mode}}",
+				}},
+			},
+			{
+				Title: "CPU Saturation",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("node_load1{%s}", nodeSelector),
+						Legend: "load1",
+					},
+					{
+						Expr:   fmt.Sprintf("count(node_cpu_seconds_total{%s, mode=\"idle\"})", nodeSelector),
+						Legend: "cpus",
+					},
+				},
+			},
+			{
+				Title:     "Container CPU Throttling",
+				Unit:      "percentunit",
+				RightUnit: "s",
+				RightAxis: []string{"throttled time"},
+				Targets: []Target{
+					{
+						Expr: fmt.Sprintf("rate(container_cpu_cfs_throttled_periods_total{%s}[$interval]) "+
+							"/ rate(container_cpu_cfs_periods_total{%s}[$interval])", serviceSelector, serviceSelector),
+						Legend: "throttled periods",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(container_cpu_cfs_throttled_seconds_total{%s}[$interval])", serviceSelector),
+						Legend: "throttled time",
+					},
+				},
+			},
+			{
+				Title: "Pressure Stall",
+				Unit:  "percentunit",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("rate(pressure_stall_seconds_total{%s}[$interval])", serviceSelector),
+					Legend: "{{resource}} {{kind}}",
+				}},
+			},
+			{
+				Title: "Memory Utilization",
+				Unit:  "bytes",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("node_memory_MemTotal_bytes{%s}", nodeSelector),
+						Legend: "total",
+					},
+					{
+						Expr:   fmt.Sprintf("node_memory_MemTotal_bytes{%s} - node_memory_MemAvailable_bytes{%s}", nodeSelector, nodeSelector),
+						Legend: "used",
+					},
+					{
+						Expr:   fmt.Sprintf("node_memory_Cached_bytes{%s} + node_memory_Buffers_bytes{%s}", nodeSelector, nodeSelector),
+						Legend: "cache + buffer",
+					},
+					{
+						Expr:   fmt.Sprintf("node_memory_SwapTotal_bytes{%s} - node_memory_SwapFree_bytes{%s}", nodeSelector, nodeSelector),
+						Legend: "swap used",
+					},
+				},
+			},
+			{
+				Title: "Memory Saturation",
+				Unit:  "ops",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("rate(node_vmstat_pgmajfault{%s}[$interval])", nodeSelector),
+						Legend: "major page faults",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(node_vmstat_pswpin{%s}[$interval]) + rate(node_vmstat_pswpout{%s}[$interval])", nodeSelector, nodeSelector),
+						Legend: "swap io",
+					},
+				},
+			},
+			{
+				Title: "Container Memory",
+				Unit:  "bytes",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("container_memory_usage_bytes{%s}", serviceSelector),
+						Legend: "usage",
+					},
+					{
+						Expr:   fmt.Sprintf("container_memory_limit_bytes{%s}", serviceSelector),
+						Legend: "limit",
+					},
+				},
+			},
+			{
+				Title: "GOMAXPROCS",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("go_sched_gomaxprocs_threads{%s}", serviceSelector),
+						Legend: "gomaxprocs",
+					},
+					{
+						Expr:   fmt.Sprintf("container_cpu_quota_cores{%s}", serviceSelector),
+						Legend: "cpu quota",
+					},
+				},
+			},
+			{
+				Title: "DB Pool Connections",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("db_in_use_connections{%s}", serviceSelector),
+						Legend: "in use",
+					},
+					{
+						Expr:   fmt.Sprintf("db_idle_connections{%s}", serviceSelector),
+						Legend: "idle",
+					},
+					{
+						Expr:   fmt.Sprintf("db_max_open_connections{%s}", serviceSelector),
+						Legend: "max open",
+					},
+				},
+			},
+			{
+				Title:     "DB Pool Waits",
+				Unit:      "ops",
+				RightUnit: "s",
+				RightAxis: []string{"wait time"},
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("rate(db_wait_count_total{%s}[$interval])", serviceSelector),
+						Legend: "waits",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(db_wait_duration_seconds_total{%s}[$interval])", serviceSelector),
+						Legend: "wait time",
+					},
+				},
+			},
+			{
+				Title: "Disk Utilization",
+				Unit:  "percentunit",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("rate(node_disk_io_time_seconds_total{%s}[$interval])", nodeSelector),
+					Legend: "{{device}}",
+				}},
+			},
+			{
+				Title: "Network Errors",
+				Unit:  "pps",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("rate(node_network_receive_errs_total{%s}[$interval])", nodeSelector),
+						Legend: "{{device}} receive",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(node_network_transmit_errs_total{%s}[$interval])", nodeSelector),
+						Legend: "{{device}} transmit",
+					},
+				},
+			},
+		},
+	}
+}
+
+// GoRuntime are the process and go runtime panels exported by the
+// prometheus client's default collectors and runtimemetrics.
+func GoRuntime() Row {
+	return Row{
+		Title: "Go Runtime",
+		Panels: []Panel{
+			{
+				Title:     "Process Memory",
+				Unit:      "bytes",
+				RightUnit: "bytes",
+				RightAxis: []string{"virtual"},
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("process_resident_memory_bytes{%s}", serviceSelector),
+						Legend: "resident",
+					},
+					{
+						Expr:   fmt.Sprintf("process_virtual_memory_bytes{%s}", serviceSelector),
+						Legend: "virtual",
+					},
+				},
+			},
+			{
+				Title:     "Process Memory Deriv",
+				Unit:      "Bps",
+				RightUnit: "Bps",
+				RightAxis: []string{"virtual"},
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("deriv(process_resident_memory_bytes{%s}[$interval])", serviceSelector),
+						Legend: "resident",
+					},
+					{
+						Expr:   fmt.Sprintf("deriv(process_virtual_memory_bytes{%s}[$interval])", serviceSelector),
+						Legend: "virtual",
+					},
+				},
+			},
+			{
+				Title:     "Go Memstats",
+				Unit:      "bytes",
+				RightUnit: "Bps",
+				RightAxis: []string{"alloc rate"},
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("go_memstats_alloc_bytes{%s}", serviceSelector),
+						Legend: "bytes allocated",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(go_memstats_alloc_bytes_total{%s}[$interval])", serviceSelector),
+						Legend: "alloc rate",
+					},
+					{
+						Expr:   fmt.Sprintf("go_memstats_stack_inuse_bytes{%s}", serviceSelector),
+						Legend: "stack inuse",
+					},
+					{
+						Expr:   fmt.Sprintf("go_memstats_heap_inuse_bytes{%s}", serviceSelector),
+						Legend: "heap inuse",
+					},
+				},
+			},
+			{
+				Title: "Go Memstats Deriv",
+				Unit:  "Bps",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("deriv(go_memstats_alloc_bytes{%s}[$interval])", serviceSelector),
+						Legend: "bytes allocated",
+					},
+					{
+						Expr:   fmt.Sprintf("deriv(go_memstats_stack_inuse_bytes{%s}[$interval])", serviceSelector),
+						Legend: "stack inuse",
+					},
+					{
+						Expr:   fmt.Sprintf("deriv(go_memstats_heap_inuse_bytes{%s}[$interval])", serviceSelector),
+						Legend: "heap inuse",
+					},
+				},
+			},
+			{
+				Title: "Goroutines",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("go_goroutines{%s}", serviceSelector),
+					Legend: "{{instance}}",
+				}},
+			},
+			{
+				Title: "GC Duration Quantiles",
+				Unit:  "s",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("go_gc_duration_seconds{%s}", serviceSelector),
+					Legend: "{{quantile}}",
+				}},
+			},
+			{
+				Title:   "Scheduler Latency",
+				Unit:    "s",
+				Targets: quantiles("go_sched_latencies_seconds", serviceSelector),
+			},
+			{
+				Title:   "GC Pauses",
+				Unit:    "s",
+				Targets: quantiles("go_gc_pauses_seconds", serviceSelector),
+			},
+			{
+				Title: "Mutex Wait",
+				Unit:  "s",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("rate(go_sync_mutex_wait_total_seconds_total{%s}[$interval])", serviceSelector),
+					Legend: "wait time / s",
+				}},
+			},
+			{
+				Title: "Heap Goal",
+				Unit:  "bytes",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("go_gc_heap_goal_bytes{%s}", serviceSelector),
+						Legend: "goal",
+					},
+					{
+						Expr:   fmt.Sprintf("go_gc_heap_live_bytes{%s}", serviceSelector),
+						Legend: "live",
+					},
+				},
+			},
+			{
+				Title: "Open FDs",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("process_open_fds{%s}", serviceSelector),
+						Legend: "open",
+					},
+					{
+						Expr:   fmt.Sprintf("process_max_fds{%s}", serviceSelector),
+						Legend: "max",
+					},
+				},
+			},
+			{
+				Title: "Open FDs Deriv",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("deriv(process_open_fds{%s}[$interval])", serviceSelector),
+					Legend: "{{instance}}",
+				}},
+			},
+		},
+	}
+}
+
+// Postgres are the panels of the database's statistics views, polled by
+// cmd/server with -pg-stats-interval.
+func Postgres() Row {
+	return Row{
+		Title: "Postgres",
+		Panels: []Panel{
+			{
+				Title: "Connections",
+				Stack: true,
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("sum(pg_stat_activity_connections{%s}) by (state, wait_event_type, wait_event)", serviceSelector),
+					Legend: "{{state}} {{wait_event_type}} {{wait_event}}",
+				}},
+			},
+			{
+				Title: "Locks",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("sum(pg_locks_count{%s}) by (mode, granted)", serviceSelector),
+					Legend: "{{mode}} granted={{granted}}",
+				}},
+			},
+			{
+				Title: "Table Scans",
+				Unit:  "ops",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_user_tables_seq_scan_total{%s}[$interval])", serviceSelector),
+						Legend: "{{relname}} sequential",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_user_tables_idx_scan_total{%s}[$interval])", serviceSelector),
+						Legend: "{{relname}} index",
+					},
+				},
+			},
+			{
+				Title: "Rows Read",
+				Unit:  "rps",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_user_tables_seq_tup_read_total{%s}[$interval])", serviceSelector),
+						Legend: "{{relname}} sequential",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_user_tables_idx_tup_fetch_total{%s}[$interval])", serviceSelector),
+						Legend: "{{relname}} index",
+					},
+				},
+			},
+			{
+				Title: "Buffer Cache Hit Ratio",
+				Unit:  "percentunit",
+				Targets: []Target{{
+					Expr: fmt.Sprintf("rate(pg_stat_database_blks_hit_total{%s}[$interval]) "+
+						"/ (rate(pg_stat_database_blks_hit_total{%s}[$interval]) + rate(pg_stat_database_blks_read_total{%s}[$interval]))",
+						serviceSelector, serviceSelector, serviceSelector),
+					Legend: "hit ratio",
+				}},
+			},
+			{
+				Title: "Transactions",
+				Unit:  "ops",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_database_xact_commit_total{%s}[$interval])", serviceSelector),
+						Legend: "commits",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_database_xact_rollback_total{%s}[$interval])", serviceSelector),
+						Legend: "rollbacks",
+					},
+					{
+						Expr:   fmt.Sprintf("rate(pg_stat_database_deadlocks_total{%s}[$interval])", serviceSelector),
+						Legend: "deadlocks",
+					},
+				},
+			},
+			{
+				Title: "Query Retries",
+				Unit:  "ops",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("sum(rate(resilience_retries_total{%s}[$interval])) by (name, result)", serviceSelector),
+						Legend: "{{name}} retry {{result}}",
+					},
+					{
+						Expr:   fmt.Sprintf("sum(rate(resilience_short_circuited_total{%s}[$interval])) by (name)", serviceSelector),
+						Legend: "{{name}} short circuited",
+					},
+				},
+			},
+			{
+				Title: "Circuit Breaker",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("resilience_breaker_state{%s}", serviceSelector),
+					Legend: "{{name}} 0 closed, 1 half-open, 2 open",
+				}},
+			},
+		},
+	}
+}
+
+// ChangeFeed are the panels of the people change feed, an asynchronous
+// pipeline whose lag and connection health don't show up in request
+// latency.
+func ChangeFeed() Row {
+	return Row{
+		Title: "Change Feed",
+		Panels: []Panel{
+			{
+				Title: "Notifications",
+				Unit:  "ops",
+				Targets: []Target{{
+					Expr:   fmt.Sprintf("sum(rate(changefeed_notifications_total{%s}[$interval])) by (op)", serviceSelector),
+					Legend: "{{op}}",
+				}},
+			},
+			{
+				Title:   "Notification Lag",
+				Unit:    "s",
+				Targets: quantiles("changefeed_notification_lag_seconds", serviceSelector),
+			},
+			{
+				Title: "Listener Connection",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("changefeed_listener_connected{%s}", serviceSelector),
+						Legend: "connected",
+					},
+					{
+						Expr:   fmt.Sprintf("sum(increase(changefeed_listener_events_total{%s}[$interval])) by (event)", serviceSelector),
+						Legend: "{{event}}",
+					},
+				},
+			},
+			{
+				Title: "Subscribers",
+				Targets: []Target{
+					{
+						Expr:   fmt.Sprintf("sum(changefeed_subscribers{%s})", serviceSelector),
+						Legend: "subscribers",
+					},
+					{
+						Expr:   fmt.Sprintf("sum(increase(changefeed_dropped_subscribers_total{%s}[$interval]))", serviceSelector),
+						Legend: "dropped",
+					},
+				},
+			},
+		},
+	}
+}
+
+// Service is the dashboard of cmd/server, provisioned into grafana as
+// config/dashboards/service.json.  serverURL is where the browser reaches
+// the server's debug endpoints.
+func Service(datasource string, latencyThreshold float64, serverURL string) *Dashboard {
+	return &Dashboard{
+		Title:       "HTTP Server",
+		Description: "HTTP Server",
+		UID:         "yvumWBFmk",
+		Datasource:  datasource,
+		Variables: []Variable{
+			{
+				Name:    "job",
+				Label:   "job",
+				Query:   "label_values(go_goroutines, job)",
+				Default: "server",
+			},
+			{
+				Name:       "instance",
+				Label:      "instance",
+				Query:      `label_values(go_goroutines{job="$job"}, instance)`,
+				IncludeAll: true,
+			},
+			{
+				Name:       "node",
+				Label:      "node",
+				Query:      `label_values(node_uname_info{job="node"}, instance)`,
+				IncludeAll: true,
+			},
+		},
+		Intervals: []string{"1m", "5m", "10m", "30m", "1h"},
+		Rows: []Row{
+			RED(latencyThreshold, serverURL),
+			USE(),
+			Postgres(),
+			ChangeFeed(),
+			GoRuntime(),
+		},
+	}
+}
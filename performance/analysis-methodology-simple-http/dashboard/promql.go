@@ -0,0 +1,533 @@
+package dashboard
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ValidateExpr parses expr with a small recursive descent parser covering
+// the PromQL the dashboards use: selectors, range and subqueries, offsets,
+// functions, aggregations, binary operators with vector matching and
+// literals.  It catches typos, unbalanced brackets, unknown functions and
+// instant vectors passed where a range vector is required, it isn't a
+// replacement for prometheus' own parser.
+func ValidateExpr(expr string) error {
+	tokens, err := lex(expr)
+	if err != nil {
+		return err
+	}
+	p := &parser{tokens: tokens}
+	if _, err := p.expr(0); err != nil {
+		return err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return fmt.Errorf("unexpected %q at %d", t.val, t.pos)
+	}
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokLBracket
+	tokRBracket
+	tokComma
+	tokColon
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+var (
+	durationRe = regexp.MustCompile(`^([0-9]+(ms|[smhdwy]))+`)
+	numberRe   = regexp.MustCompile(`^(0[xX][0-9a-fA-F]+|[0-9]*\.?[0-9]+([eE][-+]?[0-9]+)?|[Nn][Aa][Nn]|[Ii][Nn][Ff])`)
+)
+
+// ops are ordered so that longer operators are matched first
+var ops = []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "="}
+
+func lex(s string) ([]token, error) {
+	tokens := []token{}
+	brackets := 0
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i += 1
+			continue
+		case c == '#':
+			// comments run to the end of the line
+			for i < len(s) && s[i] != '\n' {
+				i += 1
+			}
+			continue
+		case c == '"' || c == '\'' || c == '`':
+			j := i + 1
+			for ; j < len(s) && rune(s[j]) != c; j++ {
+				if s[j] == '\\' && c != '`' {
+					j += 1
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, s[i : j+1], i})
+			i = j + 1
+			continue
+		case brackets > 0 && unicode.IsDigit(c):
+			m := durationRe.FindString(s[i:])
+			if m == "" {
+				return nil, fmt.Errorf("invalid duration at %d", i)
+			}
+			tokens = append(tokens, token{tokDuration, m, i})
+			i += len(m)
+			continue
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == ':' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j += 1
+			}
+			word := s[i:j]
+			if numberRe.FindString(word) == word {
+				tokens = append(tokens, token{tokNumber, word, i})
+			} else {
+				tokens = append(tokens, token{tokIdent, word, i})
+			}
+			i = j
+			continue
+		case unicode.IsDigit(c) || c == '.':
+			if m := durationRe.FindString(s[i:]); m != "" {
+				tokens = append(tokens, token{tokDuration, m, i})
+				i += len(m)
+				continue
+			}
+			m := numberRe.FindString(s[i:])
+			if m == "" {
+				return nil, fmt.Errorf("invalid number at %d", i)
+			}
+			tokens = append(tokens, token{tokNumber, m, i})
+			i += len(m)
+			continue
+		}
+
+		single := map[rune]tokenKind{
+			'(': tokLParen, ')': tokRParen,
+			'{': tokLBrace, '}': tokRBrace,
+			'[': tokLBracket, ']': tokRBracket,
+			',': tokComma, ':': tokColon,
+		}
+		if kind, ok := single[c]; ok {
+			if kind == tokLBracket {
+				brackets += 1
+			}
+			if kind == tokRBracket {
+				brackets -= 1
+			}
+			tokens = append(tokens, token{kind, string(c), i})
+			i += 1
+			continue
+		}
+
+		matched := false
+		for _, op := range ops {
+			if strings.HasPrefix(s[i:], op) {
+				tokens = append(tokens, token{tokOp, op, i})
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(s)}), nil
+}
+
+type valueType int
+
+const (
+	scalarType valueType = iota
+	stringType
+	instantType
+	rangeType
+)
+
+func (v valueType) String() string {
+	return [...]string{"scalar", "string", "instant vector", "range vector"}[v]
+}
+
+// functions maps each supported function to the types of its arguments,
+// trailing arguments past the listed ones are optional.
+var functions = map[string]struct {
+	args     []valueType
+	optional int
+	returns  valueType
+}{
+	"abs":                {[]valueType{instantType}, 0, instantType},
+	"absent":             {[]valueType{instantType}, 0, instantType},
+	"avg_over_time":      {[]valueType{rangeType}, 0, instantType},
+	"ceil":               {[]valueType{instantType}, 0, instantType},
+	"changes":            {[]valueType{rangeType}, 0, instantType},
+	"clamp_max":          {[]valueType{instantType, scalarType}, 0, instantType},
+	"clamp_min":          {[]valueType{instantType, scalarType}, 0, instantType},
+	"count_over_time":    {[]valueType{rangeType}, 0, instantType},
+	"delta":              {[]valueType{rangeType}, 0, instantType},
+	"deriv":              {[]valueType{rangeType}, 0, instantType},
+	"exp":                {[]valueType{instantType}, 0, instantType},
+	"floor":              {[]valueType{instantType}, 0, instantType},
+	"histogram_quantile": {[]valueType{scalarType, instantType}, 0, instantType},
+	"holt_winters":       {[]valueType{rangeType, scalarType, scalarType}, 0, instantType},
+	"idelta":             {[]valueType{rangeType}, 0, instantType},
+	"increase":           {[]valueType{rangeType}, 0, instantType},
+	"irate":              {[]valueType{rangeType}, 0, instantType},
+	"label_replace":      {[]valueType{instantType, stringType, stringType, stringType, stringType}, 0, instantType},
+	"ln":                 {[]valueType{instantType}, 0, instantType},
+	"log2":               {[]valueType{instantType}, 0, instantType},
+	"log10":              {[]valueType{instantType}, 0, instantType},
+	"max_over_time":      {[]valueType{rangeType}, 0, instantType},
+	"min_over_time":      {[]valueType{rangeType}, 0, instantType},
+	"predict_linear":     {[]valueType{rangeType, scalarType}, 0, instantType},
+	"quantile_over_time": {[]valueType{scalarType, rangeType}, 0, instantType},
+	"rate":               {[]valueType{rangeType}, 0, instantType},
+	"resets":             {[]valueType{rangeType}, 0, instantType},
+	"round":              {[]valueType{instantType, scalarType}, 1, instantType},
+	"scalar":             {[]valueType{instantType}, 0, scalarType},
+	"sort":               {[]valueType{instantType}, 0, instantType},
+	"sort_desc":          {[]valueType{instantType}, 0, instantType},
+	"sqrt":               {[]valueType{instantType}, 0, instantType},
+	"stddev_over_time":   {[]valueType{rangeType}, 0, instantType},
+	"sum_over_time":      {[]valueType{rangeType}, 0, instantType},
+	"time":               {[]valueType{}, 0, scalarType},
+	"timestamp":          {[]valueType{instantType}, 0, instantType},
+	"vector":             {[]valueType{scalarType}, 0, instantType},
+}
+
+// aggregations maps each aggregation operator to whether it takes a
+// parameter before the vector.
+var aggregations = map[string]bool{
+	"sum": false, "min": false, "max": false, "avg": false, "group": false,
+	"stddev": false, "stdvar": false, "count": false,
+	"count_values": true, "bottomk": true, "topk": true, "quantile": true,
+}
+
+var precedence = map[string]int{
+	"or":  1,
+	"and": 2, "unless": 2,
+	"==": 3, "!=": 3, "<=": 3, "<": 3, ">=": 3, ">": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+	"^": 6,
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i += 1
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		if t.kind == tokEOF {
+			return t, fmt.Errorf("expected %s, reached the end of the expression", what)
+		}
+		return t, fmt.Errorf("expected %s, found %q at %d", what, t.val, t.pos)
+	}
+	return t, nil
+}
+
+func (p *parser) binaryOp() (string, bool) {
+	t := p.peek()
+	if t.kind == tokOp && t.val != "=" && t.val != "=~" && t.val != "!~" {
+		return t.val, true
+	}
+	if t.kind == tokIdent && (t.val == "and" || t.val == "or" || t.val == "unless") {
+		return t.val, true
+	}
+	return "", false
+}
+
+// expr parses binary expressions whose operators bind tighter than min.
+func (p *parser) expr(min int) (valueType, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return lhs, err
+	}
+	for {
+		op, ok := p.binaryOp()
+		if !ok || precedence[op] <= min {
+			return lhs, nil
+		}
+		p.next()
+
+		if t := p.peek(); t.kind == tokIdent && t.val == "bool" {
+			p.next()
+		}
+		if err := p.vectorMatching(); err != nil {
+			return lhs, err
+		}
+
+		// ^ is right associative
+		next := precedence[op]
+		if op == "^" {
+			next -= 1
+		}
+		rhs, err := p.expr(next)
+		if err != nil {
+			return rhs, err
+		}
+		for _, side := range []valueType{lhs, rhs} {
+			if side == rangeType || side == stringType {
+				return lhs, fmt.Errorf("binary %q can't be applied to type %s", op, side)
+			}
+		}
+		if lhs == instantType || rhs == instantType {
+			lhs = instantType
+		}
+	}
+}
+
+func (p *parser) vectorMatching() error {
+	for _, keywords := range [][]string{{"on", "ignoring"}, {"group_left", "group_right"}} {
+		t := p.peek()
+		if t.kind != tokIdent || (t.val != keywords[0] && t.val != keywords[1]) {
+			continue
+		}
+		p.next()
+		if p.peek().kind != tokLParen && keywords[0] == "group_left" {
+			continue
+		}
+		if err := p.labelList(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) labelList() error {
+	if _, err := p.expect(tokLParen, "("); err != nil {
+		return err
+	}
+	for p.peek().kind != tokRParen {
+		if _, err := p.expect(tokIdent, "a label name"); err != nil {
+			return err
+		}
+		if p.peek().kind == tokComma {
+			p.next()
+		}
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) unary() (valueType, error) {
+	if t := p.peek(); t.kind == tokOp && (t.val == "-" || t.val == "+") {
+		p.next()
+	}
+	v, err := p.primary()
+	if err != nil {
+		return v, err
+	}
+	return p.postfix(v)
+}
+
+// postfix parses range selectors, subqueries and offsets.
+func (p *parser) postfix(v valueType) (valueType, error) {
+	if p.peek().kind == tokLBracket {
+		start := p.next()
+		if v != instantType {
+			return v, fmt.Errorf("range can only be applied to an instant vector, at %d", start.pos)
+		}
+		if _, err := p.expect(tokDuration, "a duration"); err != nil {
+			return v, err
+		}
+		if p.peek().kind == tokColon {
+			p.next()
+			if p.peek().kind == tokDuration {
+				p.next()
+			}
+		}
+		if _, err := p.expect(tokRBracket, "]"); err != nil {
+			return v, err
+		}
+		v = rangeType
+	}
+	if t := p.peek(); t.kind == tokIdent && t.val == "offset" {
+		p.next()
+		if _, err := p.expect(tokDuration, "an offset duration"); err != nil {
+			return v, err
+		}
+	}
+	return v, nil
+}
+
+func (p *parser) primary() (valueType, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.next()
+		return scalarType, nil
+	case tokString:
+		p.next()
+		return stringType, nil
+	case tokLParen:
+		p.next()
+		v, err := p.expr(0)
+		if err != nil {
+			return v, err
+		}
+		_, err = p.expect(tokRParen, ")")
+		return v, err
+	case tokLBrace:
+		return instantType, p.matchers(true)
+	case tokIdent:
+		p.next()
+		if _, ok := aggregations[t.val]; ok && p.peek().kind != tokLBrace {
+			return p.aggregation(t)
+		}
+		if p.peek().kind == tokLParen {
+			return p.call(t)
+		}
+		if p.peek().kind == tokLBrace {
+			return instantType, p.matchers(false)
+		}
+		return instantType, nil
+	case tokEOF:
+		return scalarType, fmt.Errorf("unexpected end of the expression")
+	}
+	return scalarType, fmt.Errorf("unexpected %q at %d", t.val, t.pos)
+}
+
+// matchers parses {label="value", ...}, a selector without a metric name
+// must have at least one matcher.
+func (p *parser) matchers(requireOne bool) error {
+	open := p.next()
+	count := 0
+	for p.peek().kind != tokRBrace {
+		if _, err := p.expect(tokIdent, "a label name"); err != nil {
+			return err
+		}
+		op, err := p.expect(tokOp, "a label matcher")
+		if err != nil {
+			return err
+		}
+		switch op.val {
+		case "=", "!=", "=~", "!~":
+		default:
+			return fmt.Errorf("invalid label matcher %q at %d", op.val, op.pos)
+		}
+		if _, err := p.expect(tokString, "a quoted label value"); err != nil {
+			return err
+		}
+		count += 1
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRBrace, "}"); err != nil {
+		return err
+	}
+	if requireOne && count == 0 {
+		return fmt.Errorf("selector at %d requires a metric name or a label matcher", open.pos)
+	}
+	return nil
+}
+
+func (p *parser) grouping() error {
+	if t := p.peek(); t.kind == tokIdent && (t.val == "by" || t.val == "without") {
+		p.next()
+		return p.labelList()
+	}
+	return nil
+}
+
+func (p *parser) aggregation(op token) (valueType, error) {
+	if err := p.grouping(); err != nil {
+		return instantType, err
+	}
+	if _, err := p.expect(tokLParen, "( after "+op.val); err != nil {
+		return instantType, err
+	}
+	if aggregations[op.val] {
+		if _, err := p.expr(0); err != nil {
+			return instantType, err
+		}
+		if _, err := p.expect(tokComma, ","); err != nil {
+			return instantType, err
+		}
+	}
+	v, err := p.expr(0)
+	if err != nil {
+		return v, err
+	}
+	if v != instantType {
+		return v, fmt.Errorf("%s expects an argument of type instant vector, found %s", op.val, v)
+	}
+	if _, err := p.expect(tokRParen, ")"); err != nil {
+		return instantType, err
+	}
+	return instantType, p.grouping()
+}
+
+func (p *parser) call(name token) (valueType, error) {
+	f, ok := functions[name.val]
+	if !ok {
+		return instantType, fmt.Errorf("unknown function %q at %d", name.val, name.pos)
+	}
+	p.next()
+
+	args := []valueType{}
+	for p.peek().kind != tokRParen {
+		v, err := p.expr(0)
+		if err != nil {
+			return v, err
+		}
+		args = append(args, v)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRParen, ")"); err != nil {
+		return f.returns, err
+	}
+
+	if len(args) > len(f.args) || len(args) < len(f.args)-f.optional {
+		return f.returns, fmt.Errorf("%s expects %d arguments, found %d", name.val, len(f.args), len(args))
+	}
+	for i, v := range args {
+		if v != f.args[i] {
+			return f.returns, fmt.Errorf("%s expects argument %d of type %s, found %s",
+				name.val, i+1, f.args[i], v)
+		}
+	}
+	return f.returns, nil
+}
@@ -0,0 +1,201 @@
+package dberr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// Class groups database errors by what the caller can do about them.
+type Class string
+
+const (
+	// Connection errors mean postgres couldn't be reached or dropped the
+	// connection, they're retryable.
+	Connection Class = "connection"
+	// Unavailable errors mean postgres is up but refusing work, ie too many
+	// connections or shutting down, they're retryable.
+	Unavailable Class = "unavailable"
+	// Canceled errors mean the query was canceled by a statement timeout or
+	// ran out of the caller's deadline.  They aren't retryable, a query that
+	// hit the statement timeout would only add load to an already slow
+	// database.
+	Canceled Class = "canceled"
+	// ClientCanceled errors mean the caller gave up, ie the client
+	// disconnected.  They aren't retryable since nobody waits for the
+	// result, and they don't say anything about the database.
+	ClientCanceled Class = "client_canceled"
+	// Serialization errors are serialization failures and deadlocks, the
+	// transaction can be retried as is.
+	Serialization Class = "serialization"
+	// Constraint errors are integrity constraint violations, retrying won't
+	// help.
+	Constraint Class = "constraint"
+	// InvalidInput errors are data exceptions caused by the arguments.
+	InvalidInput Class = "invalid_input"
+	// Internal is everything else, bugs in the query or the schema.
+	Internal Class = "internal"
+)
+
+// statusClientClosedRequest is the nginx status for requests the client
+// gave up on, nobody reads the response but it keeps them out of the 5xx.
+const statusClientClosedRequest = 499
+
+type classInfo struct {
+	retryable  bool
+	client     bool
+	httpStatus int
+}
+
+var classes = map[Class]classInfo{
+	Connection:     {retryable: true, httpStatus: http.StatusServiceUnavailable},
+	Unavailable:    {retryable: true, httpStatus: http.StatusServiceUnavailable},
+	Canceled:       {httpStatus: http.StatusGatewayTimeout},
+	ClientCanceled: {httpStatus: statusClientClosedRequest},
+	Serialization:  {retryable: true, httpStatus: http.StatusServiceUnavailable},
+	Constraint:     {client: true, httpStatus: http.StatusConflict},
+	InvalidInput:   {client: true, httpStatus: http.StatusBadRequest},
+	Internal:       {httpStatus: http.StatusInternalServerError},
+}
+
+// Error is a classified database error, Code is the SQLSTATE when the
+// error came from postgres.
+type Error struct {
+	Class Class
+	Code  string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s error (SQLSTATE %s): %s", e.Class, e.Code, e.Err)
+	}
+	return fmt.Sprintf("%s error: %s", e.Class, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable errors may succeed when the same query is tried again.
+func (e *Error) Retryable() bool {
+	return classes[e.Class].retryable
+}
+
+// Client errors are caused by the request, they don't count against the
+// service's availability.
+func (e *Error) Client() bool {
+	return classes[e.Class].client
+}
+
+func (e *Error) HTTPStatus() int {
+	return classes[e.Class].httpStatus
+}
+
+// classify maps a SQLSTATE to its class by its two character class, with
+// a few codes singled out, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+func classifyCode(code pq.ErrorCode) Class {
+	switch code {
+	case "40001", "40P01":
+		// serialization_failure, deadlock_detected
+		return Serialization
+	case "57014":
+		// query_canceled, including statement_timeout
+		return Canceled
+	case "57P01", "57P02", "57P03":
+		// admin_shutdown, crash_shutdown, cannot_connect_now
+		return Unavailable
+	}
+
+	switch code.Class() {
+	case "08":
+		return Connection
+	case "53":
+		// insufficient_resources, ie too_many_connections
+		return Unavailable
+	case "23":
+		return Constraint
+	case "22":
+		return InvalidInput
+	}
+	return Internal
+}
+
+// Classify wraps err in an *Error, errors that are already classified are
+// returned as is and nil stays nil.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return &Error{Class: classifyCode(pqErr.Code), Code: string(pqErr.Code), Err: err}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &Error{Class: ClientCanceled, Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Class: Canceled, Err: err}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return &Error{Class: Connection, Err: err}
+	}
+	return &Error{Class: Internal, Err: err}
+}
+
+// Status is the status metric label of err: success, client_error for
+// errors caused by the request, canceled when the caller gave up or error
+// for everything that counts against availability.
+func Status(err error) string {
+	if err == nil {
+		return "success"
+	}
+	e := Classify(err).(*Error)
+	switch {
+	case e.Client():
+		return "client_error"
+	case e.Class == ClientCanceled:
+		return "canceled"
+	}
+	return "error"
+}
+
+// ClassOf is the class metric label of err, empty for nil.
+func ClassOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	return string(Classify(err).(*Error).Class)
+}
+
+// HTTPStatus is the response status of err.
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	return Classify(err).(*Error).HTTPStatus()
+}
+
+// IsRetryable is true when err is classified as retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return Classify(err).(*Error).Retryable()
+}
@@ -0,0 +1,351 @@
+package faultproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	connections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "faultproxy_connections_total",
+		Help: "Client connections accepted, result=proxied|dial_error",
+	}, []string{"result"})
+
+	activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "faultproxy_active_connections",
+		Help: "Client connections currently proxied",
+	})
+
+	bytesForwarded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "faultproxy_bytes_total",
+		Help: "Bytes forwarded by direction",
+	}, []string{"direction"})
+
+	resets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "faultproxy_resets_total",
+		Help: "Connections reset, by the direction whose reset_rate reset them or api",
+	}, []string{"direction"})
+)
+
+func init() {
+	prometheus.MustRegister(connections)
+	prometheus.MustRegister(activeConnections)
+	prometheus.MustRegister(bytesForwarded)
+	prometheus.MustRegister(resets)
+}
+
+// chunk is the bytes of a single read and when they were read, latency is
+// added to the read time so a slow direction keeps its throughput.
+type chunk struct {
+	b  []byte
+	at time.Time
+}
+
+// link is a proxied client connection and its upstream connection.
+type link struct {
+	client   net.Conn
+	upstream net.Conn
+	once     sync.Once
+	done     chan struct{}
+}
+
+// reset closes both connections with an RST instead of a FIN, the way a
+// crashed server or a dropping firewall looks to postgres and the client.
+func (l *link) reset() {
+	l.once.Do(func() {
+		for _, c := range []net.Conn{l.client, l.upstream} {
+			if tcp, ok := c.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			c.Close()
+		}
+		close(l.done)
+	})
+}
+
+func (l *link) close() {
+	l.once.Do(func() {
+		l.client.Close()
+		l.upstream.Close()
+		close(l.done)
+	})
+}
+
+// Proxy forwards TCP connections to Upstream applying the toxics of each
+// direction, toxics can be changed while connections are open.
+type Proxy struct {
+	Upstream string
+
+	mu      sync.Mutex
+	toxics  map[Direction]Toxics
+	changed chan struct{}
+	links   map[*link]bool
+	rand    *rand.Rand
+}
+
+func New(upstream string) *Proxy {
+	return &Proxy{
+		Upstream: upstream,
+		toxics:   map[Direction]Toxics{},
+		changed:  make(chan struct{}),
+		links:    map[*link]bool{},
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Set replaces the toxics of direction d.
+func (p *Proxy) Set(d Direction, t Toxics) error {
+	if d != Upstream && d != Downstream {
+		return fmt.Errorf("invalid direction %q", d)
+	}
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.toxics[d] = t
+	p.notify()
+	return nil
+}
+
+// Reset removes every toxic.
+func (p *Proxy) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.toxics = map[Direction]Toxics{}
+	p.notify()
+}
+
+// notify wakes directions stalled by a blackhole so they see the new
+// toxics, p.mu must be held.
+func (p *Proxy) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *Proxy) Toxics() map[Direction]Toxics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return map[Direction]Toxics{
+		Upstream:   p.toxics[Upstream],
+		Downstream: p.toxics[Downstream],
+	}
+}
+
+func (p *Proxy) current(d Direction) (Toxics, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.toxics[d], p.changed
+}
+
+func (p *Proxy) random() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rand.Float64()
+}
+
+// ResetConnections resets every open connection and returns how many
+// were reset.
+func (p *Proxy) ResetConnections() int {
+	p.mu.Lock()
+	links := make([]*link, 0, len(p.links))
+	for l := range p.links {
+		links = append(links, l)
+	}
+	p.mu.Unlock()
+
+	for _, l := range links {
+		l.reset()
+		resets.WithLabelValues("api").Inc()
+	}
+	return len(links)
+}
+
+// Serve proxies the connections accepted by l until it's closed.
+func (p *Proxy) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	upstream, err := net.DialTimeout("tcp", p.Upstream, 10*time.Second)
+	if err != nil {
+		connections.WithLabelValues("dial_error").Inc()
+		log.Printf("dialing upstream %q: %s", p.Upstream, err)
+		client.Close()
+		return
+	}
+	connections.WithLabelValues("proxied").Inc()
+
+	l := &link{
+		client:   client,
+		upstream: upstream,
+		done:     make(chan struct{}),
+	}
+	p.mu.Lock()
+	p.links[l] = true
+	p.mu.Unlock()
+	activeConnections.Inc()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.pipe(l, Upstream, client, upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		p.pipe(l, Downstream, upstream, client)
+	}()
+	wg.Wait()
+	l.close()
+
+	p.mu.Lock()
+	delete(p.links, l)
+	p.mu.Unlock()
+	activeConnections.Dec()
+}
+
+// pipe forwards the bytes read from src to dst with the toxics of d.  The
+// reads happen in their own goroutine so latency delays bytes without
+// slowing down the reads behind them.
+func (p *Proxy) pipe(l *link, d Direction, src net.Conn, dst net.Conn) {
+	chunks := make(chan chunk, 64)
+	go func() {
+		defer close(chunks)
+		for {
+			b := make([]byte, 32*1024)
+			n, err := src.Read(b)
+			if n > 0 {
+				select {
+				case chunks <- chunk{b: b[:n], at: time.Now()}:
+				case <-l.done:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for c := range chunks {
+		t, changed := p.current(d)
+		for t.Blackhole {
+			select {
+			case <-changed:
+			case <-l.done:
+				return
+			}
+			t, changed = p.current(d)
+		}
+
+		if t.ResetRate > 0 && p.random() < t.ResetRate {
+			resets.WithLabelValues(string(d)).Inc()
+			l.reset()
+			return
+		}
+
+		if delay := p.delay(t); delay > 0 {
+			select {
+			case <-time.After(time.Until(c.at.Add(delay))):
+			case <-l.done:
+				return
+			}
+		}
+
+		n, err := dst.Write(c.b)
+		bytesForwarded.WithLabelValues(string(d)).Add(float64(n))
+		if err != nil {
+			l.close()
+			return
+		}
+
+		if t.Bandwidth > 0 {
+			select {
+			case <-time.After(time.Duration(n) * time.Second / time.Duration(t.Bandwidth)):
+			case <-l.done:
+				return
+			}
+		}
+	}
+
+	// src closed, half close dst so the other direction can finish
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		l.close()
+	}
+}
+
+func (p *Proxy) delay(t Toxics) time.Duration {
+	delay := t.Latency
+	if t.Jitter > 0 {
+		delay += time.Duration((p.random()*2 - 1) * float64(t.Jitter))
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Handler is the proxy's api:
+//
+//	GET /toxics                                 the toxics of each direction
+//	POST /toxics?direction=upstream|downstream|both&latency=100ms&jitter=20ms&bandwidth=1024&reset_rate=0.01&blackhole=true
+//	                                            replaces the toxics of direction
+//	DELETE /toxics                              removes every toxic
+//	DELETE /connections                         resets every open connection
+func (p *Proxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/toxics", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			directions, err := ParseDirection(r.FormValue("direction"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			t, err := ParseToxics(r.Form)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, d := range directions {
+				p.Set(d, t)
+			}
+		case http.MethodDelete:
+			p.Reset()
+		default:
+			http.Error(w, "expected GET, POST or DELETE", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Toxics())
+	})
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "expected DELETE", http.StatusMethodNotAllowed)
+			return
+		}
+		io.WriteString(w, fmt.Sprintf("reset %d connections\n", p.ResetConnections()))
+	})
+	return mux
+}
@@ -0,0 +1,108 @@
+package faultproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Direction is the way bytes flow through the proxy, Upstream is from the
+// client to the upstream server, ie queries, and Downstream is back from
+// the server, ie results.
+type Direction string
+
+const (
+	Upstream   Direction = "upstream"
+	Downstream Direction = "downstream"
+)
+
+func ParseDirection(s string) ([]Direction, error) {
+	switch Direction(s) {
+	case Upstream, Downstream:
+		return []Direction{Direction(s)}, nil
+	case "both":
+		return []Direction{Upstream, Downstream}, nil
+	}
+	return nil, fmt.Errorf("invalid direction %q, expected upstream|downstream|both", s)
+}
+
+// Toxics are the faults applied to every chunk of bytes read in a
+// direction, the zero value forwards bytes untouched.
+type Toxics struct {
+	// Latency delays every chunk, +/- a random Jitter.
+	Latency time.Duration
+	Jitter  time.Duration
+	// Bandwidth limits the bytes per second, 0 is unlimited.
+	Bandwidth int
+	// ResetRate is the probability, 0 to 1, that a chunk resets the
+	// connection instead of being forwarded.
+	ResetRate float64
+	// Blackhole stalls the direction, bytes are held until it's cleared,
+	// the way a hung server or a network partition looks to the client.
+	Blackhole bool
+}
+
+func (t Toxics) validate() error {
+	if t.Latency < 0 || t.Jitter < 0 {
+		return fmt.Errorf("latency and jitter must be >= 0")
+	}
+	if t.Bandwidth < 0 {
+		return fmt.Errorf("bandwidth must be >= 0")
+	}
+	if t.ResetRate < 0 || t.ResetRate > 1 {
+		return fmt.Errorf("reset_rate must be between 0 and 1, received: %v", t.ResetRate)
+	}
+	return nil
+}
+
+func (t Toxics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Latency   string  `json:"latency"`
+		Jitter    string  `json:"jitter"`
+		Bandwidth int     `json:"bandwidth"`
+		ResetRate float64 `json:"reset_rate"`
+		Blackhole bool    `json:"blackhole"`
+	}{
+		Latency:   t.Latency.String(),
+		Jitter:    t.Jitter.String(),
+		Bandwidth: t.Bandwidth,
+		ResetRate: t.ResetRate,
+		Blackhole: t.Blackhole,
+	})
+}
+
+// ParseToxics parses toxics from url values or a query string of the same
+// form, ie "latency=100ms&jitter=20ms&bandwidth=1024&reset_rate=0.01&blackhole=true",
+// missing values are zero.
+func ParseToxics(v url.Values) (Toxics, error) {
+	t := Toxics{}
+	var err error
+	if s := v.Get("latency"); s != "" {
+		if t.Latency, err = time.ParseDuration(s); err != nil {
+			return t, fmt.Errorf("invalid latency: %s", err)
+		}
+	}
+	if s := v.Get("jitter"); s != "" {
+		if t.Jitter, err = time.ParseDuration(s); err != nil {
+			return t, fmt.Errorf("invalid jitter: %s", err)
+		}
+	}
+	if s := v.Get("bandwidth"); s != "" {
+		if t.Bandwidth, err = strconv.Atoi(s); err != nil {
+			return t, fmt.Errorf("invalid bandwidth: %s", err)
+		}
+	}
+	if s := v.Get("reset_rate"); s != "" {
+		if t.ResetRate, err = strconv.ParseFloat(s, 64); err != nil {
+			return t, fmt.Errorf("invalid reset_rate: %s", err)
+		}
+	}
+	if s := v.Get("blackhole"); s != "" {
+		if t.Blackhole, err = strconv.ParseBool(s); err != nil {
+			return t, fmt.Errorf("invalid blackhole: %s", err)
+		}
+	}
+	return t, t.validate()
+}
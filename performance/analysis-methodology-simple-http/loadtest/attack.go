@@ -0,0 +1,114 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a single request.
+type Result struct {
+	Start   time.Time
+	Latency time.Duration
+	Code    int
+	Error   string
+}
+
+func (r Result) Success() bool {
+	return r.Error == "" && r.Code >= 200 && r.Code < 400
+}
+
+// Attacker sends requests open loop, each request is sent on schedule
+// regardless of how long earlier ones take, so a slow server shows up as
+// latency rather than as a lower request rate.
+type Attacker struct {
+	client *http.Client
+}
+
+func NewAttacker(timeout time.Duration, maxConns int) *Attacker {
+	return &Attacker{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: maxConns,
+			},
+		},
+	}
+}
+
+func (a *Attacker) hit(ctx context.Context, p *Profile) Result {
+	var body io.Reader
+	if p.body != nil {
+		body = bytes.NewReader(p.body)
+	}
+
+	r := Result{
+		Start: time.Now(),
+	}
+	req, err := http.NewRequest(p.Method, p.Target, body)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if p.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		r.Latency = time.Since(r.Start)
+		r.Error = err.Error()
+		return r
+	}
+	// the response is only complete once the body has been read
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	r.Latency = time.Since(r.Start)
+	r.Code = resp.StatusCode
+	return r
+}
+
+// Attack runs every stage of p and returns the result of every request,
+// ordered by when they were sent.
+func (a *Attacker) Attack(ctx context.Context, p *Profile) []Result {
+	mu := sync.Mutex{}
+	results := []Result{}
+	wg := sync.WaitGroup{}
+
+	for _, s := range p.Stages {
+		d, _ := time.ParseDuration(s.Duration)
+		interval := time.Second / time.Duration(s.Rate)
+		ticker := time.NewTicker(interval)
+		end := time.After(d)
+
+	stage:
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				break stage
+			case <-end:
+				ticker.Stop()
+				break stage
+			case <-ticker.C:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					r := a.hit(ctx, p)
+					mu.Lock()
+					results = append(results, r)
+					mu.Unlock()
+				}()
+			}
+		}
+	}
+	wg.Wait()
+
+	sortResults(results)
+	return results
+}
@@ -0,0 +1,70 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Profiler downloads pprof profiles from the server's /debug/pprof
+// handlers.
+type Profiler struct {
+	url    string
+	client *http.Client
+}
+
+// NewProfiler returns a profiler of the server at url, ie
+// http://localhost:8080.
+func NewProfiler(url string) *Profiler {
+	return &Profiler{
+		url:    url,
+		client: &http.Client{},
+	}
+}
+
+func (p *Profiler) download(ctx context.Context, path string, out string) error {
+	req, err := http.NewRequest(http.MethodGet, p.url+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s%s received status %d", p.url, path, resp.StatusCode)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CPU profiles the server for d and writes the profile to out, it blocks
+// for the length of the profile.
+func (p *Profiler) CPU(ctx context.Context, d time.Duration, out string) error {
+	seconds := int(d.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return p.download(ctx, fmt.Sprintf("/debug/pprof/profile?seconds=%d", seconds), out)
+}
+
+func (p *Profiler) Heap(ctx context.Context, out string) error {
+	return p.download(ctx, "/debug/pprof/heap", out)
+}
+
+func (p *Profiler) Goroutine(ctx context.Context, out string) error {
+	return p.download(ctx, "/debug/pprof/goroutine", out)
+}
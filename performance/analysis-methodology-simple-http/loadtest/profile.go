@@ -0,0 +1,125 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stage sends Rate requests per second for Duration.
+type Stage struct {
+	Rate     int    `json:"rate"`
+	Duration string `json:"duration"`
+}
+
+// maxRate is well beyond what a single attacker sends, requests are sent
+// on a ticker whose interval has to stay above 0.
+const maxRate = 100000
+
+// Profile is a named load test, the stages run back to back against
+// Target.  Body is a path to the request body, relative paths are relative
+// to the profiles file.
+type Profile struct {
+	Name    string  `json:"name"`
+	Method  string  `json:"method"`
+	Target  string  `json:"target"`
+	Body    string  `json:"body"`
+	Timeout string  `json:"timeout"`
+	Stages  []Stage `json:"stages"`
+
+	body []byte
+}
+
+type Profiles struct {
+	Profiles []Profile `json:"profiles"`
+}
+
+func LoadProfiles(path string) (*Profiles, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ps := &Profiles{}
+	if err := json.NewDecoder(f).Decode(ps); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+
+	for i := range ps.Profiles {
+		p := &ps.Profiles[i]
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if p.Body == "" {
+			continue
+		}
+		bodyPath := p.Body
+		if !filepath.IsAbs(bodyPath) {
+			bodyPath = filepath.Join(filepath.Dir(path), bodyPath)
+		}
+		p.body, err = ioutil.ReadFile(bodyPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+func (ps *Profiles) Get(name string) (*Profile, error) {
+	for i := range ps.Profiles {
+		if ps.Profiles[i].Name == name {
+			return &ps.Profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no load profile named %q", name)
+}
+
+func (p *Profile) Validate() error {
+	if p.Name == "" || p.Target == "" {
+		return fmt.Errorf("load profile requires a name and a target")
+	}
+	if p.Method == "" {
+		p.Method = "GET"
+	}
+	if p.Timeout == "" {
+		p.Timeout = "30s"
+	}
+	if _, err := time.ParseDuration(p.Timeout); err != nil {
+		return fmt.Errorf("profile %q: invalid timeout: %s", p.Name, err)
+	}
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("profile %q requires at least one stage", p.Name)
+	}
+	for _, s := range p.Stages {
+		if s.Rate <= 0 || s.Rate > maxRate {
+			return fmt.Errorf("profile %q: stage rate must be between 1 and %d, received %d", p.Name, maxRate, s.Rate)
+		}
+		d, err := time.ParseDuration(s.Duration)
+		if err != nil {
+			return fmt.Errorf("profile %q: invalid stage duration: %s", p.Name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("profile %q: stage duration must be positive, received %q", p.Name, s.Duration)
+		}
+	}
+	return nil
+}
+
+func (p *Profile) TimeoutDuration() time.Duration {
+	d, _ := time.ParseDuration(p.Timeout)
+	return d
+}
+
+// Duration is the length of all the stages.
+func (p *Profile) Duration() time.Duration {
+	total := time.Duration(0)
+	for _, s := range p.Stages {
+		d, _ := time.ParseDuration(s.Duration)
+		total += d
+	}
+	return total
+}
@@ -0,0 +1,195 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+func sortResults(results []Result) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Start.Before(results[j].Start)
+	})
+}
+
+// Latencies are client side latency percentiles, in seconds so they can be
+// compared with the server's histograms.
+type Latencies struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// Summary is the client side view of a run, it's what runs are compared
+// on.
+type Summary struct {
+	RunID    string         `json:"run_id"`
+	Profile  string         `json:"profile"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Requests int            `json:"requests"`
+	Success  int            `json:"success"`
+	Codes    map[string]int `json:"codes"`
+	Errors   map[string]int `json:"errors"`
+	// Throughput is successful requests per second between Start and End,
+	// End is when the last stage was scheduled to end so requests that
+	// were still in flight count but the wait for them doesn't.
+	Throughput  float64   `json:"throughput"`
+	SuccessRate float64   `json:"success_rate"`
+	Latencies   Latencies `json:"latencies"`
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i].Seconds()
+}
+
+func NewSummary(runID string, profile string, start time.Time, end time.Time, results []Result) Summary {
+	s := Summary{
+		RunID:    runID,
+		Profile:  profile,
+		Start:    start,
+		End:      end,
+		Requests: len(results),
+		Codes:    map[string]int{},
+		Errors:   map[string]int{},
+	}
+
+	latencies := []time.Duration{}
+	total := time.Duration(0)
+	for _, r := range results {
+		if r.Error != "" {
+			s.Errors[r.Error] += 1
+		} else {
+			s.Codes[fmt.Sprintf("%d", r.Code)] += 1
+		}
+		if r.Success() {
+			s.Success += 1
+		}
+		latencies = append(latencies, r.Latency)
+		total += r.Latency
+	}
+	if len(results) == 0 {
+		return s
+	}
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	s.Latencies = Latencies{
+		Mean: (total / time.Duration(len(results))).Seconds(),
+		P50:  percentile(latencies, 0.50),
+		P90:  percentile(latencies, 0.90),
+		P99:  percentile(latencies, 0.99),
+		Max:  latencies[len(latencies)-1].Seconds(),
+	}
+	s.SuccessRate = float64(s.Success) / float64(s.Requests)
+	if d := end.Sub(start).Seconds(); d > 0 {
+		s.Throughput = float64(s.Success) / d
+	}
+	return s
+}
+
+func LoadSummary(path string) (*Summary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &Summary{}
+	if err := json.NewDecoder(f).Decode(s); err != nil {
+		return nil, fmt.Errorf("decoding %q: %s", path, err)
+	}
+	return s, nil
+}
+
+func (s Summary) Write(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Thresholds are how much worse than the baseline a run may be, as a
+// fraction of the baseline's value.
+type Thresholds struct {
+	P99         float64
+	Throughput  float64
+	SuccessRate float64
+}
+
+type Regression struct {
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Run      float64 `json:"run"`
+	Change   float64 `json:"change"`
+	Allowed  float64 `json:"allowed"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s regressed %+.1f%% (baseline %.4g, run %.4g), allowed %.1f%%",
+		r.Metric, r.Change*100, r.Baseline, r.Run, r.Allowed*100)
+}
+
+func change(baseline float64, run float64) float64 {
+	if baseline == 0 {
+		return 0
+	}
+	return (run - baseline) / baseline
+}
+
+// Compare returns the regressions of run against baseline, a higher p99 or
+// a lower throughput or success rate beyond the thresholds.
+func Compare(baseline Summary, run Summary, t Thresholds) []Regression {
+	regressions := []Regression{}
+
+	if c := change(baseline.Latencies.P99, run.Latencies.P99); c > t.P99 {
+		regressions = append(regressions, Regression{
+			Metric:   "p99 latency",
+			Baseline: baseline.Latencies.P99,
+			Run:      run.Latencies.P99,
+			Change:   c,
+			Allowed:  t.P99,
+		})
+	}
+	if c := change(baseline.Throughput, run.Throughput); -c > t.Throughput {
+		regressions = append(regressions, Regression{
+			Metric:   "throughput",
+			Baseline: baseline.Throughput,
+			Run:      run.Throughput,
+			Change:   c,
+			Allowed:  t.Throughput,
+		})
+	}
+	if c := change(baseline.SuccessRate, run.SuccessRate); -c > t.SuccessRate {
+		regressions = append(regressions, Regression{
+			Metric:   "success rate",
+			Baseline: baseline.SuccessRate,
+			Run:      run.SuccessRate,
+			Change:   c,
+			Allowed:  t.SuccessRate,
+		})
+	}
+	return regressions
+}
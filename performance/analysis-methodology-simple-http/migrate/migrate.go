@@ -0,0 +1,345 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migration is a numbered schema change, Up applies it and Down reverts
+// it.  They're read from NNNN_name.up.sql and NNNN_name.down.sql files.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations of fsys.  Every version needs both an up and a
+// down file and versions must be contiguous from 1, so a missing or
+// misnumbered file is caught before anything is applied.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		m := migrationFile.FindStringSubmatch(f.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected file %q, migrations are named NNNN_name.up.sql or NNNN_name.down.sql", f.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		b, err := fs.ReadFile(fsys, f.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("version %d is named both %q and %q", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(b)
+		} else {
+			migration.Down = string(b)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("expected version %d, found %d_%s, versions must be contiguous from 1", i+1, m.Version, m.Name)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%d_%s requires both an up and a down file", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS migrations (
+    migration_set text NOT NULL,
+    version integer NOT NULL,
+    name text NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL default current_timestamp,
+    PRIMARY KEY (migration_set, version)
+)`
+
+// Migrator applies a set of migrations to a database and tracks which
+// ones are applied in the migrations table.  Several sets, ie the people
+// schema and cloudprober's metrics table, can share a database.
+type Migrator struct {
+	db         *sql.DB
+	set        string
+	migrations []Migration
+}
+
+func New(db *sql.DB, set string, migrations []Migration) *Migrator {
+	return &Migrator{
+		db:         db,
+		set:        set,
+		migrations: migrations,
+	}
+}
+
+// Status is a migration and when it was applied, AppliedAt is nil when it
+// hasn't been.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Latest is the version the migrations bring the schema to.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// tracked reports whether the migrations table exists, Current, Status and
+// Check only read so they can run against a database nothing has
+// migrated yet.
+func (m *Migrator) tracked(ctx context.Context) (bool, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass('migrations') IS NOT NULL`).Scan(&exists)
+	return exists, err
+}
+
+// Current is the latest applied version, 0 when none are.
+func (m *Migrator) Current(ctx context.Context) (int, error) {
+	exists, err := m.tracked(ctx)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = m.db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM migrations WHERE migration_set = $1`, m.set).Scan(&version)
+	return version, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, migration := range m.migrations {
+		s := Status{Migration: migration}
+		if at, ok := applied[migration.Version]; ok {
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// applied returns when each applied version was applied.
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	applied := map[int]time.Time{}
+	exists, err := m.tracked(ctx)
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := m.db.QueryContext(ctx,
+		`SELECT version, applied_at FROM migrations WHERE migration_set = $1`, m.set)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// lock serializes migrators of the same set for the rest of tx, so two
+// servers starting at once can't apply a migration twice.
+func (m *Migrator) lock(ctx context.Context, tx *sql.Tx) error {
+	h := fnv.New32a()
+	h.Write([]byte("migrate:" + m.set))
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(h.Sum32()))
+	return err
+}
+
+// step applies or reverts a single migration in a transaction with its
+// tracking row, a failed migration leaves the schema untouched.
+func (m *Migrator) step(ctx context.Context, migration Migration, up bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.lock(ctx, tx); err != nil {
+		return err
+	}
+
+	var applied bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM migrations WHERE migration_set = $1 AND version = $2)`,
+		m.set, migration.Version).Scan(&applied)
+	if err != nil {
+		return err
+	}
+	if applied == up {
+		// another migrator got here first
+		return nil
+	}
+
+	if up {
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("%d_%s up: %s", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO migrations (migration_set, version, name) VALUES ($1, $2, $3)`,
+			m.set, migration.Version, migration.Name)
+	} else {
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return fmt.Errorf("%d_%s down: %s", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM migrations WHERE migration_set = $1 AND version = $2`,
+			m.set, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies every migration after the current version up to and
+// including target, 0 means the latest.  It returns the applied
+// migrations.
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	if target == 0 {
+		target = m.Latest()
+	}
+	if target < 0 || target > m.Latest() {
+		return nil, fmt.Errorf("target version %d is outside of 1-%d", target, m.Latest())
+	}
+
+	if _, err := m.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, err
+	}
+	current, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("schema is at version %d which is newer than the known migrations, %d", current, m.Latest())
+	}
+	if current > target {
+		return nil, fmt.Errorf("schema is at version %d which is past target version %d, use down to revert", current, target)
+	}
+
+	applied := []Migration{}
+	for _, migration := range m.migrations[current:target] {
+		if err := m.step(ctx, migration, true); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Down reverts the latest steps applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("schema is at version %d which is newer than the known migrations, %d", current, m.Latest())
+	}
+
+	reverted := []Migration{}
+	for v := current; v > 0 && v > current-steps; v-- {
+		migration := m.migrations[v-1]
+		if err := m.step(ctx, migration, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+// Baseline adopts a database whose schema was created before it was
+// migrated, ie by the old config/postgres/schema.sh, by recording versions
+// 1 to version as applied without running them.  It refuses a set that
+// already has applied migrations.
+func (m *Migrator) Baseline(ctx context.Context, version int) ([]Migration, error) {
+	if version < 1 || version > m.Latest() {
+		return nil, fmt.Errorf("baseline version %d is outside of 1-%d", version, m.Latest())
+	}
+	if _, err := m.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := m.lock(ctx, tx); err != nil {
+		return nil, err
+	}
+	var current int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM migrations WHERE migration_set = $1`, m.set).Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+	if current != 0 {
+		return nil, fmt.Errorf("%s schema is already at version %d, only unmigrated databases can be baselined", m.set, current)
+	}
+
+	for _, migration := range m.migrations[:version] {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO migrations (migration_set, version, name) VALUES ($1, $2, $3)`,
+			m.set, migration.Version, migration.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.migrations[:version], tx.Commit()
+}
+
+// Check returns an error unless the schema is at the latest version.
+func (m *Migrator) Check(ctx context.Context) error {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current != m.Latest() {
+		return fmt.Errorf("%s schema is at version %d, expected %d", m.set, current, m.Latest())
+	}
+	return nil
+}
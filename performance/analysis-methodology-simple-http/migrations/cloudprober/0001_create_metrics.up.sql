@@ -0,0 +1,8 @@
+-- cloudprober's postgres surfacer, metrics_table_name: "metrics"
+CREATE TABLE metrics (
+    time TIMESTAMP WITH TIME ZONE,
+    metric_name text NOT NULL,
+    value DOUBLE PRECISION,
+    labels jsonb,
+    PRIMARY KEY (time, metric_name, labels)
+);
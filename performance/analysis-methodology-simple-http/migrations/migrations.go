@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/dm03514/analysis-methodology-simple-http/migrate"
+)
+
+// The migrations are embedded so a binary always carries the schema it
+// expects.  Each directory is a set that's tracked separately.
+
+//go:embed people cloudprober
+var files embed.FS
+
+// Sets are the names of every migration set.
+func Sets() []string {
+	entries, _ := fs.ReadDir(files, ".")
+	sets := []string{}
+	for _, e := range entries {
+		sets = append(sets, e.Name())
+	}
+	sort.Strings(sets)
+	return sets
+}
+
+// Load returns the migrations of set.
+func Load(set string) ([]migrate.Migration, error) {
+	if _, err := fs.Stat(files, set); err != nil {
+		return nil, fmt.Errorf("unknown migration set %q, expected one of %q", set, Sets())
+	}
+	sub, err := fs.Sub(files, set)
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(sub)
+}
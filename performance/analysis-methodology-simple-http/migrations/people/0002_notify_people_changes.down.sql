@@ -0,0 +1,4 @@
+DROP TRIGGER people_notify ON people;
+DROP FUNCTION people_notify();
+DROP TRIGGER people_touch ON people;
+DROP FUNCTION people_touch();
@@ -0,0 +1,40 @@
+-- last_updated_time is bumped on every update so change feed clients can
+-- resume from the last change they saw.
+CREATE FUNCTION people_touch() RETURNS trigger AS $$
+BEGIN
+    NEW.last_updated_time := current_timestamp;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER people_touch
+    BEFORE UPDATE ON people
+    FOR EACH ROW EXECUTE PROCEDURE people_touch();
+
+-- Every change is sent to the people_changes channel when its transaction
+-- commits, at is when the row changed so listeners can measure their lag.
+CREATE FUNCTION people_notify() RETURNS trigger AS $$
+DECLARE
+    person people;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        person := OLD;
+    ELSE
+        person := NEW;
+    END IF;
+
+    PERFORM pg_notify('people_changes', json_build_object(
+        'op', TG_OP,
+        'address', person.address,
+        'full_name', person.full_name,
+        'age', person.age,
+        'last_updated_time', person.last_updated_time,
+        'at', clock_timestamp()
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER people_notify
+    AFTER INSERT OR UPDATE OR DELETE ON people
+    FOR EACH ROW EXECUTE PROCEDURE people_notify();
@@ -0,0 +1,310 @@
+package pathology
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is a performance pathology that can be injected into requests, each
+// one shows up on a different panel of the service dashboard.
+type Mode string
+
+const (
+	// GoroutineLeak starts Size goroutines that block forever: Goroutines
+	// and the goroutine profile.
+	GoroutineLeak Mode = "goroutine-leak"
+	// FDLeak opens Size files that are never closed: Open FDs and Open FDs
+	// Deriv.
+	FDLeak Mode = "fd-leak"
+	// HeapGrowth retains Size bytes forever: Process Memory and Go
+	// Memstats Deriv and the heap profile.
+	HeapGrowth Mode = "heap-growth"
+	// CPUHash runs Size rounds of sha256: CPU Utilization, request latency
+	// and the cpu profile.
+	CPUHash Mode = "cpu-hash"
+	// LockContention holds a mutex shared by every request for Size
+	// microseconds: request latency with idle CPU and the mutex profile.
+	LockContention Mode = "lock-contention"
+	// AllocPressure allocates Size bytes of small short lived objects: the
+	// allocation rate and GC Duration Quantiles.
+	AllocPressure Mode = "alloc-pressure"
+)
+
+var modes = map[Mode]bool{
+	GoroutineLeak:  true,
+	FDLeak:         true,
+	HeapGrowth:     true,
+	CPUHash:        true,
+	LockContention: true,
+	AllocPressure:  true,
+}
+
+// Setting controls how often a mode is injected, Rate is the fraction of
+// requests, 0 to 1, and Size its magnitude in the mode's unit.
+type Setting struct {
+	Rate float64 `json:"rate"`
+	Size int     `json:"size"`
+}
+
+func (s Setting) validate(m Mode) error {
+	if !modes[m] {
+		return fmt.Errorf("unknown mode %q", m)
+	}
+	if s.Rate < 0 || s.Rate > 1 {
+		return fmt.Errorf("%s: rate must be between 0 and 1, received %v", m, s.Rate)
+	}
+	if s.Size < 0 {
+		return fmt.Errorf("%s: size can't be negative, received %d", m, s.Size)
+	}
+	return nil
+}
+
+// Injector applies the enabled modes to each request and holds onto
+// everything that's leaked so it can be released by Reset.
+type Injector struct {
+	mu       sync.Mutex
+	settings map[Mode]Setting
+	rand     *rand.Rand
+
+	// block is never closed until Reset, leaked goroutines wait on it
+	block  chan struct{}
+	files  []*os.File
+	heap   [][]byte
+	global sync.Mutex
+}
+
+func NewInjector() *Injector {
+	return &Injector{
+		settings: map[Mode]Setting{},
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		block:    make(chan struct{}),
+	}
+}
+
+// Set enables m, a zero Rate disables it.  Enabling LockContention turns
+// on the mutex and block profiles so the contention can be found in pprof.
+func (i *Injector) Set(m Mode, s Setting) error {
+	if err := s.validate(m); err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if s.Rate == 0 {
+		delete(i.settings, m)
+	} else {
+		i.settings[m] = s
+	}
+
+	if m == LockContention {
+		fraction, rate := 0, 0
+		if s.Rate > 0 {
+			fraction, rate = 5, 1
+		}
+		runtime.SetMutexProfileFraction(fraction)
+		runtime.SetBlockProfileRate(rate)
+	}
+	return nil
+}
+
+func (i *Injector) Settings() map[Mode]Setting {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	settings := map[Mode]Setting{}
+	for m, s := range i.settings {
+		settings[m] = s
+	}
+	return settings
+}
+
+// Reset disables every mode and releases everything that was leaked.
+func (i *Injector) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for m := range i.settings {
+		delete(i.settings, m)
+	}
+	close(i.block)
+	i.block = make(chan struct{})
+	for _, f := range i.files {
+		f.Close()
+	}
+	i.files = nil
+	i.heap = nil
+	runtime.SetMutexProfileFraction(0)
+	runtime.SetBlockProfileRate(0)
+}
+
+// Inject applies each enabled mode with the probability of its rate, it's
+// called once per request.
+func (i *Injector) Inject() {
+	i.mu.Lock()
+	inject := map[Mode]int{}
+	for m, s := range i.settings {
+		if i.rand.Float64() < s.Rate {
+			inject[m] = s.Size
+		}
+	}
+	i.mu.Unlock()
+
+	for m, size := range inject {
+		switch m {
+		case GoroutineLeak:
+			i.leakGoroutines(size)
+		case FDLeak:
+			i.leakFDs(size)
+		case HeapGrowth:
+			i.growHeap(size)
+		case CPUHash:
+			hash(size)
+		case LockContention:
+			i.global.Lock()
+			time.Sleep(time.Duration(size) * time.Microsecond)
+			i.global.Unlock()
+		case AllocPressure:
+			allocate(size)
+		}
+	}
+}
+
+func (i *Injector) leakGoroutines(n int) {
+	i.mu.Lock()
+	block := i.block
+	i.mu.Unlock()
+	for j := 0; j < n; j++ {
+		go func() {
+			<-block
+		}()
+	}
+}
+
+func (i *Injector) leakFDs(n int) {
+	for j := 0; j < n; j++ {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			// most likely out of fds, which is the point
+			return
+		}
+		i.mu.Lock()
+		i.files = append(i.files, f)
+		i.mu.Unlock()
+	}
+}
+
+func (i *Injector) growHeap(n int) {
+	b := make([]byte, n)
+	// touch every page so the growth shows up in the resident memory
+	for j := 0; j < len(b); j += 4096 {
+		b[j] = 1
+	}
+	i.mu.Lock()
+	i.heap = append(i.heap, b)
+	i.mu.Unlock()
+}
+
+func hash(rounds int) [sha256.Size]byte {
+	sum := sha256.Sum256([]byte("pathology"))
+	for j := 0; j < rounds; j++ {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum
+}
+
+func allocate(n int) int {
+	objects := [][]byte{}
+	for j := 0; j < n; j += 64 {
+		objects = append(objects, make([]byte, 64))
+	}
+	return len(objects)
+}
+
+// ParseSettings parses mode=rate:size pairs separated by commas, ie
+// goroutine-leak=1:10,cpu-hash=0.5:100000.
+func ParseSettings(s string) (map[Mode]Setting, error) {
+	settings := map[Mode]Setting{}
+	if s == "" {
+		return settings, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid pathology %q, expected mode=rate:size", pair)
+		}
+		m := Mode(strings.TrimSpace(parts[0]))
+
+		values := strings.SplitN(parts[1], ":", 2)
+		if len(values) != 2 {
+			return nil, fmt.Errorf("invalid pathology %q, expected mode=rate:size", pair)
+		}
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid rate: %s", m, err)
+		}
+		size, err := strconv.Atoi(values[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid size: %s", m, err)
+		}
+
+		setting := Setting{Rate: rate, Size: size}
+		if err := setting.validate(m); err != nil {
+			return nil, err
+		}
+		settings[m] = setting
+	}
+	return settings, nil
+}
+
+// Handler is the admin endpoint of the injector.  GET lists the enabled
+// modes, POST ?mode=&rate=&size= sets one and DELETE resets everything.
+func (i *Injector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			rate, err := strconv.ParseFloat(r.FormValue("rate"), 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid rate: %s", err), http.StatusBadRequest)
+				return
+			}
+			size := 0
+			if s := r.FormValue("size"); s != "" {
+				if size, err = strconv.Atoi(s); err != nil {
+					http.Error(w, fmt.Sprintf("invalid size: %s", err), http.StatusBadRequest)
+					return
+				}
+			}
+			if err := i.Set(Mode(r.FormValue("mode")), Setting{Rate: rate, Size: size}); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			i.Reset()
+		default:
+			http.Error(w, "expected GET, POST or DELETE", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(i.Settings())
+	})
+}
+
+// Modes are the names of every mode, for usage messages.
+func Modes() []string {
+	names := []string{}
+	for m := range modes {
+		names = append(names, string(m))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,292 @@
+package pgstats
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	activityDesc = prometheus.NewDesc(
+		"pg_stat_activity_connections",
+		"Connections to the database by state and what they're waiting on, from pg_stat_activity",
+		[]string{"state", "wait_event_type", "wait_event"}, nil)
+	seqScanDesc = prometheus.NewDesc(
+		"pg_stat_user_tables_seq_scan_total",
+		"# of sequential scans of the table",
+		[]string{"relname"}, nil)
+	seqTupReadDesc = prometheus.NewDesc(
+		"pg_stat_user_tables_seq_tup_read_total",
+		"# of rows read by sequential scans of the table",
+		[]string{"relname"}, nil)
+	idxScanDesc = prometheus.NewDesc(
+		"pg_stat_user_tables_idx_scan_total",
+		"# of index scans of the table",
+		[]string{"relname"}, nil)
+	idxTupFetchDesc = prometheus.NewDesc(
+		"pg_stat_user_tables_idx_tup_fetch_total",
+		"# of rows fetched by index scans of the table",
+		[]string{"relname"}, nil)
+	liveTupDesc = prometheus.NewDesc(
+		"pg_stat_user_tables_live_tuples",
+		"Estimated # of live rows in the table",
+		[]string{"relname"}, nil)
+	blksHitDesc = prometheus.NewDesc(
+		"pg_stat_database_blks_hit_total",
+		"# of blocks found in the buffer cache",
+		nil, nil)
+	blksReadDesc = prometheus.NewDesc(
+		"pg_stat_database_blks_read_total",
+		"# of blocks read from disk",
+		nil, nil)
+	deadlocksDesc = prometheus.NewDesc(
+		"pg_stat_database_deadlocks_total",
+		"# of deadlocks detected",
+		nil, nil)
+	xactCommitDesc = prometheus.NewDesc(
+		"pg_stat_database_xact_commit_total",
+		"# of committed transactions",
+		nil, nil)
+	xactRollbackDesc = prometheus.NewDesc(
+		"pg_stat_database_xact_rollback_total",
+		"# of rolled back transactions",
+		nil, nil)
+	tempBytesDesc = prometheus.NewDesc(
+		"pg_stat_database_temp_bytes_total",
+		"Bytes written to temporary files by queries that didn't fit in work_mem",
+		nil, nil)
+	locksDesc = prometheus.NewDesc(
+		"pg_locks_count",
+		"Locks held, granted=true, or waited for, granted=false, in the database by mode",
+		[]string{"mode", "granted"}, nil)
+	upDesc = prometheus.NewDesc(
+		"pg_stats_up",
+		"1 when the last poll of the postgres statistics views succeeded",
+		nil, nil)
+	pollDurationDesc = prometheus.NewDesc(
+		"pg_stats_poll_duration_seconds",
+		"Duration of the last poll of the postgres statistics views",
+		nil, nil)
+)
+
+const (
+	activityQuery = `
+SELECT COALESCE(state, ''), COALESCE(wait_event_type, ''), COALESCE(wait_event, ''), count(*)
+FROM pg_stat_activity
+WHERE datname = current_database() AND pid <> pg_backend_pid()
+GROUP BY 1, 2, 3`
+
+	tablesQuery = `
+SELECT relname, seq_scan, seq_tup_read, COALESCE(idx_scan, 0), COALESCE(idx_tup_fetch, 0), n_live_tup
+FROM pg_stat_user_tables
+WHERE relname::text = ANY($1)`
+
+	databaseQuery = `
+SELECT blks_hit, blks_read, deadlocks, xact_commit, xact_rollback, temp_bytes
+FROM pg_stat_database
+WHERE datname = current_database()`
+
+	locksQuery = `
+SELECT mode, granted, count(*)
+FROM pg_locks
+WHERE database = (SELECT oid FROM pg_database WHERE datname = current_database())
+GROUP BY mode, granted`
+)
+
+type labeled struct {
+	labels []string
+	value  float64
+}
+
+// snapshot is the result of a single poll, it's exported until the next
+// poll replaces it.
+type snapshot struct {
+	metrics  map[*prometheus.Desc][]labeled
+	up       bool
+	duration time.Duration
+}
+
+func (s *snapshot) add(desc *prometheus.Desc, value float64, labels ...string) {
+	s.metrics[desc] = append(s.metrics[desc], labeled{labels: labels, value: value})
+}
+
+var counters = map[*prometheus.Desc]bool{
+	seqScanDesc:      true,
+	seqTupReadDesc:   true,
+	idxScanDesc:      true,
+	idxTupFetchDesc:  true,
+	blksHitDesc:      true,
+	blksReadDesc:     true,
+	deadlocksDesc:    true,
+	xactCommitDesc:   true,
+	xactRollbackDesc: true,
+	tempBytesDesc:    true,
+}
+
+// Collector polls postgres's statistics views, the USE of the database:
+// connections and what they wait on, sequential vs index scans, the
+// buffer cache and locks.  Polling happens on its own connection so it
+// doesn't show up in the server's pool.
+type Collector struct {
+	db     *sql.DB
+	tables []string
+
+	mu   sync.Mutex
+	last *snapshot
+}
+
+// NewCollector collects the statistics of tables from db, which should be
+// limited to a single connection.
+func NewCollector(db *sql.DB, tables []string) *Collector {
+	return &Collector{
+		db:     db,
+		tables: tables,
+		last:   &snapshot{metrics: map[*prometheus.Desc][]labeled{}},
+	}
+}
+
+func (c *Collector) poll(ctx context.Context) (*snapshot, error) {
+	s := &snapshot{metrics: map[*prometheus.Desc][]labeled{}}
+
+	rows, err := c.db.QueryContext(ctx, activityQuery)
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		var state, waitEventType, waitEvent string
+		var count float64
+		if err := rows.Scan(&state, &waitEventType, &waitEvent, &count); err != nil {
+			rows.Close()
+			return s, err
+		}
+		s.add(activityDesc, count, state, waitEventType, waitEvent)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	rows, err = c.db.QueryContext(ctx, tablesQuery, pq.Array(c.tables))
+	if err != nil {
+		return s, err
+	}
+	for rows.Next() {
+		var relname string
+		var seqScan, seqTupRead, idxScan, idxTupFetch, liveTup float64
+		if err := rows.Scan(&relname, &seqScan, &seqTupRead, &idxScan, &idxTupFetch, &liveTup); err != nil {
+			rows.Close()
+			return s, err
+		}
+		s.add(seqScanDesc, seqScan, relname)
+		s.add(seqTupReadDesc, seqTupRead, relname)
+		s.add(idxScanDesc, idxScan, relname)
+		s.add(idxTupFetchDesc, idxTupFetch, relname)
+		s.add(liveTupDesc, liveTup, relname)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return s, err
+	}
+
+	var blksHit, blksRead, deadlocks, xactCommit, xactRollback, tempBytes float64
+	err = c.db.QueryRowContext(ctx, databaseQuery).Scan(
+		&blksHit, &blksRead, &deadlocks, &xactCommit, &xactRollback, &tempBytes)
+	if err != nil {
+		return s, err
+	}
+	s.add(blksHitDesc, blksHit)
+	s.add(blksReadDesc, blksRead)
+	s.add(deadlocksDesc, deadlocks)
+	s.add(xactCommitDesc, xactCommit)
+	s.add(xactRollbackDesc, xactRollback)
+	s.add(tempBytesDesc, tempBytes)
+
+	rows, err = c.db.QueryContext(ctx, locksQuery)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mode string
+		var granted bool
+		var count float64
+		if err := rows.Scan(&mode, &granted, &count); err != nil {
+			return s, err
+		}
+		g := "false"
+		if granted {
+			g = "true"
+		}
+		s.add(locksDesc, count, mode, g)
+	}
+	return s, rows.Err()
+}
+
+// Run polls every interval until ctx is done.  A failed poll keeps the
+// counters of the previous one, so rates aren't reset, and sets pg_stats_up
+// to 0.
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		s, err := c.poll(pollCtx)
+		cancel()
+		s.duration = time.Since(start)
+
+		c.mu.Lock()
+		if err != nil {
+			log.Printf("polling postgres statistics: %s", err)
+			c.last.up = false
+			c.last.duration = s.duration
+		} else {
+			s.up = true
+			c.last = s
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		activityDesc, seqScanDesc, seqTupReadDesc, idxScanDesc, idxTupFetchDesc, liveTupDesc,
+		blksHitDesc, blksReadDesc, deadlocksDesc, xactCommitDesc, xactRollbackDesc, tempBytesDesc,
+		locksDesc, upDesc, pollDurationDesc,
+	} {
+		ch <- d
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for desc, values := range c.last.metrics {
+		valueType := prometheus.GaugeValue
+		if counters[desc] {
+			valueType = prometheus.CounterValue
+		}
+		for _, v := range values {
+			ch <- prometheus.MustNewConstMetric(desc, valueType, v.value, v.labels...)
+		}
+	}
+
+	up := 0.0
+	if c.last.up {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(pollDurationDesc, prometheus.GaugeValue, c.last.duration.Seconds())
+}
@@ -0,0 +1,164 @@
+package promapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a single labeled series of a query result, instant queries
+// have a single sample per series.
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Client queries the prometheus HTTP API, ie http://localhost:9090.
+type Client struct {
+	url    string
+	client *http.Client
+}
+
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+type apiResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string            `json:"resultType"`
+		Result     []json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type apiSeries struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// parseSample parses a [<unix seconds>, "<value>"] pair.
+func parseSample(pair []interface{}) (Sample, error) {
+	if len(pair) != 2 {
+		return Sample{}, fmt.Errorf("expected a [timestamp, value] pair, received %v", pair)
+	}
+	ts, ok := pair[0].(float64)
+	if !ok {
+		return Sample{}, fmt.Errorf("invalid timestamp %v", pair[0])
+	}
+	s, ok := pair[1].(string)
+	if !ok {
+		return Sample{}, fmt.Errorf("invalid value %v", pair[1])
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Sample{}, err
+	}
+	sec := int64(ts)
+	return Sample{
+		Time:  time.Unix(sec, int64((ts-float64(sec))*1e9)),
+		Value: v,
+	}, nil
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
+
+func (c *Client) get(ctx context.Context, path string, params url.Values) ([]Series, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := apiResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("decoding %s response with status %d: %s", path, resp.StatusCode, err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("query %q failed: %s: %s", params.Get("query"), r.ErrorType, r.Error)
+	}
+
+	switch r.Data.ResultType {
+	case "matrix", "vector":
+	case "scalar":
+		// scalars are a single pair rather than a list of series
+		var pair []interface{}
+		if len(r.Data.Result) == 0 {
+			return nil, nil
+		}
+		raw, _ := json.Marshal(r.Data.Result)
+		if err := json.Unmarshal(raw, &pair); err != nil {
+			return nil, err
+		}
+		s, err := parseSample(pair)
+		if err != nil {
+			return nil, err
+		}
+		return []Series{{Labels: map[string]string{}, Samples: []Sample{s}}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported result type %q", r.Data.ResultType)
+	}
+
+	series := []Series{}
+	for _, raw := range r.Data.Result {
+		as := apiSeries{}
+		if err := json.Unmarshal(raw, &as); err != nil {
+			return nil, err
+		}
+		s := Series{
+			Labels: as.Metric,
+		}
+		if as.Value != nil {
+			as.Values = append(as.Values, as.Value)
+		}
+		for _, pair := range as.Values {
+			sample, err := parseSample(pair)
+			if err != nil {
+				return nil, err
+			}
+			s.Samples = append(s.Samples, sample)
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// Query evaluates query at a single point in time.
+func (c *Client) Query(ctx context.Context, query string, at time.Time) ([]Series, error) {
+	return c.get(ctx, "/api/v1/query", url.Values{
+		"query": {query},
+		"time":  {formatTime(at)},
+	})
+}
+
+// QueryRange evaluates query every step over [start, end].
+func (c *Client) QueryRange(ctx context.Context, query string, start time.Time, end time.Time, step time.Duration) ([]Series, error) {
+	return c.get(ctx, "/api/v1/query_range", url.Values{
+		"query": {query},
+		"start": {formatTime(start)},
+		"end":   {formatTime(end)},
+		"step":  {strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+	})
+}
@@ -0,0 +1,44 @@
+package resilience
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff is exponential backoff with full jitter, the delay before retry
+// n is random between 0 and min(Max, Base * 2^n).  Jitter spreads out the
+// retries of requests that failed together, ie when postgres restarts, so
+// they don't arrive as another burst.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func NewBackoff(base time.Duration, max time.Duration) *Backoff {
+	return &Backoff{
+		Base: base,
+		Max:  max,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Delay is the delay before retry n, the first retry is 0.
+func (b *Backoff) Delay(retry int) time.Duration {
+	ceiling := b.Max
+	if retry < 62 {
+		if d := b.Base << uint(retry); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Duration(b.rand.Int63n(int64(ceiling) + 1))
+}
@@ -0,0 +1,144 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned instead of calling through an open breaker.
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	Closed State = iota
+	HalfOpen
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half-open"
+	case Open:
+		return "open"
+	}
+	return "unknown"
+}
+
+// Outcome is the result of a call as far as the breaker is concerned.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Ignored calls say nothing about the dependency, ie the caller
+	// canceled them, they neither close nor open the breaker.
+	Ignored
+)
+
+// Breaker stops calls to a dependency that keeps failing.  It opens after
+// Failures consecutive failures and fails every call fast for OpenFor, it
+// then lets a single probe call through, half-open, which closes it again
+// on success or reopens it on failure.  Every transition starts a new
+// generation, the outcomes of calls allowed in an earlier one are
+// ignored so a slow call can't close the breaker it didn't probe.
+type Breaker struct {
+	Failures int
+	OpenFor  time.Duration
+	// OnStateChange is called with the breaker's lock held when it
+	// transitions.
+	OnStateChange func(from State, to State)
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	probing    bool
+}
+
+func NewBreaker(failures int, openFor time.Duration) *Breaker {
+	return &Breaker{
+		Failures: failures,
+		OpenFor:  openFor,
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) transition(to State) {
+	from := b.state
+	if from == to {
+		return
+	}
+	b.state = to
+	b.generation++
+	b.failures = 0
+	if b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+// Allow reports whether a call may go through and returns the generation
+// it was allowed in, every allowed call must be followed by Record.
+func (b *Breaker) Allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) < b.OpenFor {
+			return 0, ErrOpen
+		}
+		b.transition(HalfOpen)
+		b.probing = true
+	case HalfOpen:
+		if b.probing {
+			return 0, ErrOpen
+		}
+		b.probing = true
+	}
+	return b.generation, nil
+}
+
+// Record reports the outcome of a call allowed in generation.
+func (b *Breaker) Record(generation uint64, o Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if b.state == HalfOpen {
+		b.probing = false
+		switch o {
+		case Failure:
+			b.openedAt = time.Now()
+			b.transition(Open)
+		case Success:
+			b.transition(Closed)
+		}
+		// an ignored probe lets the next call probe instead
+		return
+	}
+
+	switch o {
+	case Success:
+		b.failures = 0
+	case Failure:
+		b.failures++
+		if b.failures >= b.Failures {
+			b.openedAt = time.Now()
+			b.transition(Open)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// Budget limits retries to a fraction of calls so retries can't multiply
+// the load on a struggling dependency.  Every call deposits Ratio tokens
+// and every retry withdraws one, MinPerSecond tokens are added over time
+// so low traffic can still retry.  Unused tokens are capped so an idle
+// period doesn't save up a burst of retries.
+type Budget struct {
+	Ratio        float64
+	MinPerSecond float64
+
+	mu      sync.Mutex
+	tokens  float64
+	max     float64
+	updated time.Time
+}
+
+func NewBudget(ratio float64, minPerSecond float64) *Budget {
+	max := minPerSecond * 10
+	if max < 10 {
+		max = 10
+	}
+	return &Budget{
+		Ratio:        ratio,
+		MinPerSecond: minPerSecond,
+		tokens:       max,
+		max:          max,
+		updated:      time.Now(),
+	}
+}
+
+func (b *Budget) add(tokens float64) {
+	now := time.Now()
+	b.tokens += tokens + b.MinPerSecond*now.Sub(b.updated).Seconds()
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.updated = now
+}
+
+// Deposit records a call.
+func (b *Budget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(b.Ratio)
+}
+
+// Withdraw reports whether a retry is within the budget and, if it is,
+// spends it.
+func (b *Budget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.add(0)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
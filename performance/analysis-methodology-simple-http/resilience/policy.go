@@ -0,0 +1,135 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resilience_retries_total",
+		Help: "Retries of failed calls, result=attempted|budget_exhausted|deadline",
+	}, []string{"name", "result"})
+
+	shortCircuited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resilience_short_circuited_total",
+		Help: "Calls failed fast by an open circuit breaker",
+	}, []string{"name"})
+
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resilience_breaker_state",
+		Help: "State of the circuit breaker, 0 closed, 1 half-open, 2 open",
+	}, []string{"name"})
+
+	breakerTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "resilience_breaker_transitions_total",
+		Help: "Circuit breaker state transitions by the state transitioned to",
+	}, []string{"name", "state"})
+)
+
+func init() {
+	prometheus.MustRegister(retries)
+	prometheus.MustRegister(shortCircuited)
+	prometheus.MustRegister(breakerState)
+	prometheus.MustRegister(breakerTransitions)
+}
+
+// Policy calls through a circuit breaker and retries failures that are
+// Retryable with backoff, up to MaxAttempts calls in total and within the
+// retry budget.  Errors that aren't Failures, ie ones caused by the
+// request, don't trip the breaker and calls the caller canceled are
+// ignored by it.
+type Policy struct {
+	Name        string
+	MaxAttempts int
+	Backoff     *Backoff
+	Budget      *Budget
+	Breaker     *Breaker
+	Retryable   func(error) bool
+	Failure     func(error) bool
+}
+
+// NewPolicy returns a policy whose metrics are labelled with name.
+func NewPolicy(name string, maxAttempts int, backoff *Backoff, budget *Budget, breaker *Breaker,
+	retryable func(error) bool, failure func(error) bool) *Policy {
+
+	breakerState.WithLabelValues(name).Set(float64(breaker.State()))
+	breaker.OnStateChange = func(from State, to State) {
+		breakerState.WithLabelValues(name).Set(float64(to))
+		breakerTransitions.WithLabelValues(name, to.String()).Inc()
+	}
+	return &Policy{
+		Name:        name,
+		MaxAttempts: maxAttempts,
+		Backoff:     backoff,
+		Budget:      budget,
+		Breaker:     breaker,
+		Retryable:   retryable,
+		Failure:     failure,
+	}
+}
+
+func (p *Policy) call(ctx context.Context, fn func(context.Context) error) error {
+	generation, err := p.Breaker.Allow()
+	if err != nil {
+		shortCircuited.WithLabelValues(p.Name).Inc()
+		return err
+	}
+	err = fn(ctx)
+	p.Breaker.Record(generation, p.outcome(ctx, err))
+	return err
+}
+
+func (p *Policy) outcome(ctx context.Context, err error) Outcome {
+	switch {
+	case err == nil:
+		return Success
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return Ignored
+	case p.Failure(err):
+		return Failure
+	}
+	return Success
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't
+// retryable, or the attempts, budget or ctx run out.  The last error is
+// returned.
+func (p *Policy) Do(ctx context.Context, fn func(context.Context) error) error {
+	p.Budget.Deposit()
+
+	var err error
+	for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			// a caller that gave up doesn't spend the budget
+			if ctx.Err() != nil {
+				return err
+			}
+			if !p.Budget.Withdraw() {
+				retries.WithLabelValues(p.Name, "budget_exhausted").Inc()
+				return err
+			}
+
+			delay := p.Backoff.Delay(attempt - 1)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+				retries.WithLabelValues(p.Name, "deadline").Inc()
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+			retries.WithLabelValues(p.Name, "attempted").Inc()
+		}
+
+		err = p.call(ctx, fn)
+		if err == nil || err == ErrOpen || !p.Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
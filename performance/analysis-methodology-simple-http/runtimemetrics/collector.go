@@ -0,0 +1,178 @@
+package runtimemetrics
+
+import (
+	"math"
+	"runtime/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The vendored client_golang only exports runtime.MemStats, these are the
+// runtime/metrics it's missing, named the way newer client_golang releases
+// export them.
+
+// latencyBuckets are the upper bounds, in seconds, the runtime's fine
+// grained histograms are folded into.
+var latencyBuckets = []float64{
+	.000001, .000005, .00001, .00005, .0001, .00025, .0005,
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1,
+}
+
+type histogram struct {
+	name string
+	desc *prometheus.Desc
+}
+
+type value struct {
+	name      string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+var (
+	histograms = []histogram{
+		{
+			name: "/sched/latencies:seconds",
+			desc: prometheus.NewDesc(
+				"go_sched_latencies_seconds",
+				"Distribution of the time goroutines spent runnable before running, scheduling delay",
+				nil, nil),
+		},
+		{
+			name: "/gc/pauses:seconds",
+			desc: prometheus.NewDesc(
+				"go_gc_pauses_seconds",
+				"Distribution of individual stop the world GC pauses",
+				nil, nil),
+		},
+	}
+
+	values = []value{
+		{
+			name: "/sync/mutex/wait/total:seconds",
+			desc: prometheus.NewDesc(
+				"go_sync_mutex_wait_total_seconds_total",
+				"Approximate total time goroutines spent blocked on a sync.Mutex or sync.RWMutex",
+				nil, nil),
+			valueType: prometheus.CounterValue,
+		},
+		{
+			name: "/gc/heap/goal:bytes",
+			desc: prometheus.NewDesc(
+				"go_gc_heap_goal_bytes",
+				"Heap size the GC aims to finish the current cycle at",
+				nil, nil),
+			valueType: prometheus.GaugeValue,
+		},
+		{
+			name: "/gc/heap/live:bytes",
+			desc: prometheus.NewDesc(
+				"go_gc_heap_live_bytes",
+				"Heap memory occupied by live objects marked by the previous GC",
+				nil, nil),
+			valueType: prometheus.GaugeValue,
+		},
+	}
+)
+
+// Collector reads runtime/metrics on each scrape, metrics the running go
+// version doesn't support are skipped.
+type Collector struct {
+	histograms []histogram
+	values     []value
+	samples    []metrics.Sample
+}
+
+func NewCollector() *Collector {
+	supported := map[string]bool{}
+	for _, d := range metrics.All() {
+		supported[d.Name] = true
+	}
+
+	c := &Collector{}
+	for _, h := range histograms {
+		if supported[h.name] {
+			c.histograms = append(c.histograms, h)
+			c.samples = append(c.samples, metrics.Sample{Name: h.name})
+		}
+	}
+	for _, v := range values {
+		if supported[v.name] {
+			c.values = append(c.values, v)
+			c.samples = append(c.samples, metrics.Sample{Name: v.name})
+		}
+	}
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, h := range c.histograms {
+		ch <- h.desc
+	}
+	for _, v := range c.values {
+		ch <- v.desc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	samples := make([]metrics.Sample, len(c.samples))
+	copy(samples, c.samples)
+	metrics.Read(samples)
+
+	for i, h := range c.histograms {
+		fh := samples[i].Value.Float64Histogram()
+		count, sum, buckets := fold(fh, latencyBuckets)
+		ch <- prometheus.MustNewConstHistogram(h.desc, count, sum, buckets)
+	}
+	for i, v := range c.values {
+		s := samples[len(c.histograms)+i]
+		var f float64
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			f = float64(s.Value.Uint64())
+		case metrics.KindFloat64:
+			f = s.Value.Float64()
+		default:
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(v.desc, v.valueType, f)
+	}
+}
+
+// fold converts a runtime histogram into cumulative prometheus buckets.  A
+// runtime bucket is counted in every upper bound it fits under.  The
+// runtime doesn't track the sum so it's estimated from each bucket's
+// midpoint.
+func fold(h *metrics.Float64Histogram, bounds []float64) (uint64, float64, map[float64]uint64) {
+	buckets := map[float64]uint64{}
+	for _, b := range bounds {
+		buckets[b] = 0
+	}
+
+	var count uint64
+	var sum float64
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		lower, upper := h.Buckets[i], h.Buckets[i+1]
+		count += n
+		sum += float64(n) * midpoint(lower, upper)
+		for _, b := range bounds {
+			if upper <= b {
+				buckets[b] += n
+			}
+		}
+	}
+	return count, sum, buckets
+}
+
+func midpoint(lower float64, upper float64) float64 {
+	switch {
+	case math.IsInf(lower, -1):
+		return upper
+	case math.IsInf(upper, 1):
+		return lower
+	}
+	return (lower + upper) / 2
+}
@@ -0,0 +1,227 @@
+package slowquery
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	slowQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "slow_queries_total",
+		Help: "# of queries slower than the slow query threshold, see /debug/slowqueries",
+	}, []string{"name"})
+
+	explains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "slow_query_explains_total",
+		Help: "# of slow queries explained, status=success|error|skipped",
+	}, []string{"name", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(slowQueries)
+	prometheus.MustRegister(explains)
+}
+
+// Entry is a single slow query.  Plan is the EXPLAIN (ANALYZE, BUFFERS)
+// output when the query was sampled, it's filled in asynchronously.
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	Name      string        `json:"name"`
+	Query     string        `json:"query"`
+	Args      []string      `json:"args"`
+	Duration  time.Duration `json:"-"`
+	Plan      string        `json:"plan,omitempty"`
+	PlanError string        `json:"plan_error,omitempty"`
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		entry
+		DurationMS float64 `json:"duration_ms"`
+	}{
+		entry:      entry(e),
+		DurationMS: e.Duration.Seconds() * 1000,
+	})
+}
+
+// Log records queries slower than its threshold in a ring buffer and
+// explains a sample of them.  EXPLAIN ANALYZE executes the query again so
+// it runs in a transaction that's rolled back, on its own connection, and
+// at most one explain runs at a time.  A nil Log records nothing.
+type Log struct {
+	db          *sql.DB
+	threshold   time.Duration
+	explainRate float64
+	timeout     time.Duration
+	explaining  chan struct{}
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	entries []*Entry
+	next    int
+}
+
+// New returns a log of queries slower than threshold, explainRate of them,
+// 0 to 1, are explained on db.  The size most recent slow queries are kept.
+func New(db *sql.DB, threshold time.Duration, explainRate float64, size int) (*Log, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("slow query log size must be >= 1, received: %d", size)
+	}
+	return &Log{
+		db:          db,
+		threshold:   threshold,
+		explainRate: explainRate,
+		timeout:     30 * time.Second,
+		explaining:  make(chan struct{}, 1),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		entries:     make([]*Entry, size),
+	}, nil
+}
+
+// Observe records the query when d is over the threshold.
+func (l *Log) Observe(name string, query string, args []interface{}, d time.Duration) {
+	if l == nil || d < l.threshold {
+		return
+	}
+
+	e := &Entry{
+		Time:     time.Now().Add(-d),
+		Name:     name,
+		Query:    strings.Join(strings.Fields(query), " "),
+		Duration: d,
+	}
+	for _, a := range args {
+		e.Args = append(e.Args, fmt.Sprintf("%v", a))
+	}
+	slowQueries.WithLabelValues(name).Inc()
+	log.Printf("slow_query: %q, duration: %s, args: %q", name, d, e.Args)
+
+	l.mu.Lock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	sampled := l.rand.Float64() < l.explainRate
+	l.mu.Unlock()
+
+	if !sampled {
+		return
+	}
+	select {
+	case l.explaining <- struct{}{}:
+		go func() {
+			defer func() { <-l.explaining }()
+			l.explain(e, query, args)
+		}()
+	default:
+		explains.WithLabelValues(name, "skipped").Inc()
+	}
+}
+
+func (l *Log) explain(e *Entry, query string, args []interface{}) {
+	plan, err := l.plan(query, args)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		explains.WithLabelValues(e.Name, "error").Inc()
+		e.PlanError = err.Error()
+		return
+	}
+	explains.WithLabelValues(e.Name, "success").Inc()
+	e.Plan = plan
+}
+
+func (l *Log) plan(query string, args []interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+
+	tx, err := l.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	lines := []string{}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// Entries returns the recorded slow queries, newest first.
+func (l *Log) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for i := 1; i <= len(l.entries); i++ {
+		e := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if e == nil {
+			break
+		}
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+// Handler serves the slow queries as json, or with ?format=text as text
+// with each plan under its query.  ?name= filters by query name.
+func (l *Log) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l == nil {
+			http.Error(w, "the slow query log is disabled, see -slow-query-threshold", http.StatusNotFound)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		entries := []Entry{}
+		for _, e := range l.Entries() {
+			if name == "" || e.Name == name {
+				entries = append(entries, e)
+			}
+		}
+
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, e := range entries {
+				fmt.Fprintf(w, "%s %s %s args=%q\n%s\n", e.Time.Format(time.RFC3339), e.Name, e.Duration, e.Args, e.Query)
+				if e.Plan != "" {
+					fmt.Fprintf(w, "%s\n", e.Plan)
+				}
+				if e.PlanError != "" {
+					fmt.Fprintf(w, "explain failed: %s\n", e.PlanError)
+				}
+				fmt.Fprintln(w)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Threshold float64 `json:"threshold_ms"`
+			Entries   []Entry `json:"entries"`
+		}{
+			Threshold: l.threshold.Seconds() * 1000,
+			Entries:   entries,
+		})
+	})
+}